@@ -0,0 +1,183 @@
+package tns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Analyzer 分析链: 字符过滤 -> 分词 -> 词元过滤. Analyzer 实现了 Tokenizer,
+// 可以传给 NewIndexer / NewSearcher 或通过 AddAnalyzer 按字段使用.
+//
+// 字符过滤会改变文本, Analyzer 负责把词元的 Start / End 还原为原文中的偏移.
+// 词元的 Pos 为分词结果中的序号 (MultiTokenizer 自己设置位置), 词元过滤可以删除词元 (留下位置空缺) 或在同一位置插入词元.
+type Analyzer struct {
+	CharFilters  []CharFilter
+	Tokenizer    Tokenizer
+	TokenFilters []TokenFilter
+}
+
+// CharFilter 分词前修改原文, 通过 w 写出过滤后的文本
+type CharFilter interface {
+	Filter(text string, w *CharWriter)
+}
+
+// TokenFilter 修改分词结果, 可以在原切片上修改
+type TokenFilter interface {
+	Filter(terms []Term) []Term
+}
+
+func (a *Analyzer) Tokenzie(text string, searchMode bool) []Term {
+	var m *offsetMap
+	for _, f := range a.CharFilters {
+		w := &CharWriter{m: &offsetMap{srcLen: len(text)}}
+		f.Filter(text, w)
+		text = w.b.String()
+		m = w.m.chain(m)
+	}
+
+	terms := a.Tokenizer.Tokenzie(text, searchMode)
+	for j := range terms {
+		t := &terms[j]
+		if !positioned(a.Tokenizer) {
+			t.Pos = j
+		}
+		if m != nil {
+			t.Start, t.End = m.start(t.Start), m.end(t.end())
+		}
+	}
+
+	for _, f := range a.TokenFilters {
+		terms = f.Filter(terms)
+	}
+	return terms
+}
+
+// CharWriter 收集字符过滤的输出, 并记录输出的每个字节对应的原文区间
+type CharWriter struct {
+	b strings.Builder
+	m *offsetMap
+}
+
+// Write 写出 s, s 对应原文 text[start:end]. 长度相同时逐字节对应, 否则 s 的每个字节都对应整个区间
+func (w *CharWriter) Write(s string, start, end int) {
+	w.b.WriteString(s)
+	for k := 0; k < len(s); k++ {
+		if len(s) == end-start {
+			w.m.starts = append(w.m.starts, start+k)
+			w.m.ends = append(w.m.ends, start+k+1)
+		} else {
+			w.m.starts = append(w.m.starts, start)
+			w.m.ends = append(w.m.ends, end)
+		}
+	}
+}
+
+// offsetMap 过滤后文本每个字节对应的原文区间 [starts[i], ends[i])
+type offsetMap struct {
+	starts, ends []int
+	srcLen       int
+}
+
+// start 过滤后文本中的起始偏移 p 对应的原文偏移
+func (m *offsetMap) start(p int) int {
+	if p >= len(m.starts) {
+		return m.srcLen
+	}
+	return m.starts[p]
+}
+
+// end 过滤后文本中的结束偏移 p 对应的原文偏移
+func (m *offsetMap) end(p int) int {
+	if p <= 0 {
+		return 0
+	}
+	if p > len(m.ends) {
+		return m.srcLen
+	}
+	return m.ends[p-1]
+}
+
+// chain 返回先经过 prev 再经过 m 的映射, prev 为 nil 时返回 m
+func (m *offsetMap) chain(prev *offsetMap) *offsetMap {
+	if prev == nil {
+		return m
+	}
+	for i := range m.starts {
+		m.starts[i], m.ends[i] = prev.start(m.starts[i]), prev.end(m.ends[i])
+	}
+	m.srcLen = prev.srcLen
+	return m
+}
+
+// analyze 使用 t 切分 text, t 不是 Analyzer 或 MultiTokenizer 时词元的位置为其序号
+func analyze(t Tokenizer, text string, searchMode bool) []Term {
+	if a, ok := t.(*Analyzer); ok {
+		return a.Tokenzie(text, searchMode)
+	}
+
+	terms := t.Tokenzie(text, searchMode)
+	if positioned(t) {
+		return terms
+	}
+	for j := range terms {
+		terms[j].Pos = j
+	}
+	return terms
+}
+
+// analysis 按字段定义选择分词器, Indexer 与 Searcher 共用, 保证索引与查询时的切分一致
+type analysis struct {
+	t Tokenizer
+	// analyzers 字段可以通过 FieldSpec.Analyzer 引用的分词器
+	analyzers map[string]Tokenizer
+}
+
+func newAnalysis(t Tokenizer) analysis {
+	return analysis{t: t, analyzers: make(map[string]Tokenizer)}
+}
+
+// AddAnalyzer 注册名为 name 的分词器 (可以是 Analyzer), 供 FieldSpec.Analyzer 使用.
+// Indexer 与 Searcher 需要注册相同的分词器
+func (a *analysis) AddAnalyzer(name string, t Tokenizer) {
+	a.analyzers[name] = t
+}
+
+// analyze 按字段定义把字段值切分为词元: 文本字段使用字段的分词器, 其余类型的字段整体作为一个词元,
+// 未建立索引的字段返回空
+func (a *analysis) analyze(spec *IndexSpec, field, text string, searchMode bool) ([]Term, error) {
+	// 拼音子字段: 按原字段切分后转换为拼音
+	if base, ok := strings.CutSuffix(field, PinyinSuffix); ok && spec != nil {
+		if f := spec.Field(base); f != nil && f.Pinyin {
+			terms, err := a.analyze(spec, base, text, searchMode)
+			return PinyinFilter{}.Filter(terms), err
+		}
+	}
+
+	var f *FieldSpec
+	if spec != nil {
+		f = spec.Field(field)
+	}
+	if f == nil {
+		return analyze(a.t, text, searchMode), nil
+	}
+
+	if !f.Indexed {
+		return nil, nil
+	}
+
+	var terms []Term
+	if f.Type == FieldText {
+		t := a.t
+		if f.Analyzer != "" {
+			var ok bool
+			if t, ok = a.analyzers[f.Analyzer]; !ok {
+				return nil, fmt.Errorf("field %q: unknown analyzer %q", field, f.Analyzer)
+			}
+		}
+		terms = analyze(t, text, searchMode)
+	} else {
+		terms = []Term{{Text: text, End: len(text)}}
+	}
+
+	return terms, nil
+}
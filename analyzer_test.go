@@ -0,0 +1,213 @@
+package tns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// fieldsTokenizer 按空白切分
+type fieldsTokenizer struct{}
+
+func (fieldsTokenizer) Tokenzie(text string, searchMode bool) []Term {
+	var terms []Term
+	start := -1
+	for i, r := range text + " " {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				terms = append(terms, Term{Text: text[start:i], Start: start, End: i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	return terms
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := &Analyzer{
+		CharFilters:  []CharFilter{HTMLStripFilter{}, WidthFilter{}},
+		Tokenizer:    fieldsTokenizer{},
+		TokenFilters: []TokenFilter{LowercaseFilter{}, NewStopFilter("the"), NewSynonymFilter([]string{"quick", "fast"})},
+	}
+
+	text := "<b>The</b> ＱＵＩＣＫ&nbsp;Brown fox"
+	got := a.Tokenzie(text, false)
+	want := []Term{
+		{Text: "quick", Start: 11, End: 26, Pos: 1},
+		{Text: "fast", Start: 11, End: 26, Pos: 1},
+		{Text: "brown", Start: 32, End: 37, Pos: 2},
+		{Text: "fox", Start: 38, End: 41, Pos: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if s := text[got[0].Start:got[0].End]; s != "ＱＵＩＣＫ" {
+		t.Fatalf("offsets point to %q", s)
+	}
+}
+
+func TestWikiStripFilter(t *testing.T) {
+	text := "'''北京'''是{{Lang|zh|中国}}的[[首都|首府]], 见 [[File:a.jpg|图]] [http://x.cn 官网]\n== 历史 =="
+	w := &CharWriter{m: &offsetMap{srcLen: len(text)}}
+	WikiStripFilter{}.Filter(text, w)
+
+	got := strings.Join(strings.Fields(w.b.String()), " ")
+	if want := "北京 是 的首府, 见 官网 历史"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	out := w.b.String()
+	i := strings.Index(out, "首府")
+	if s := text[w.m.start(i):w.m.end(i+len("首府"))]; s != "首府" {
+		t.Fatalf("offsets point to %q", s)
+	}
+}
+
+func TestPhraseTerms(t *testing.T) {
+	terms, offsets := phraseTerms([]Term{{Text: "a", Pos: 3}, {Text: "a2", Pos: 3}, {Text: "b", Pos: 5}})
+	if len(terms) != 2 || terms[1].Text != "b" || !reflect.DeepEqual(offsets, []int{0, 2}) {
+		t.Fatalf("got %v %v", terms, offsets)
+	}
+}
+
+func TestKeywordFilter(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		keywords []string
+		want     []Term
+	}{
+		{"北京 是 中国 的 首都", []string{"北京", "首都"}, []Term{{Text: "北京", Pos: 0}, {Text: "首都", Pos: 4}}},
+		// 中英文混合, 相邻的英文单词不能粘连成 searchengine
+		{"用 go 写 search engine", []string{"search", "用"}, []Term{{Text: "用", Pos: 0}, {Text: "search", Pos: 3}}},
+	} {
+		f := &KeywordFilter{TopK: 2, Extract: func(text string, topK int) []string {
+			if text != c.text || topK != 2 {
+				t.Fatalf("extract %q %d", text, topK)
+			}
+			return c.keywords
+		}}
+
+		terms := analyze(fieldsTokenizer{}, c.text, false)
+		for i := range terms {
+			terms[i].Start, terms[i].End = 0, 0
+		}
+		got := f.Filter(terms)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("got %+v, want %+v", got, c.want)
+		}
+	}
+}
+
+func TestChineseFilter(t *testing.T) {
+	t2s := NewChineseFilter(TraditionalToSimplified)
+	if got := t2s.Convert("臺灣的電腦網路"); got != "台湾的电脑网路" {
+		t.Fatalf("got %q", got)
+	}
+
+	for trad, simp := range map[string]string{
+		"鑰匙":    "钥匙",
+		"瀋陽故宮":  "沈阳故宫",
+		"西廂記":   "西厢记",
+		"上顎":    "上颚",
+		"頭髮與發財": "头发与发财",
+		"皇帝的後宮": "皇帝的后宫",
+	} {
+		if got := t2s.Convert(trad); got != simp {
+			t.Fatalf("t2s %q: got %q, want %q", trad, got, simp)
+		}
+	}
+
+	s2t := NewChineseFilter(SimplifiedToTraditional)
+	if a, b := s2t.Convert("台湾"), s2t.Convert("臺灣"); a != "臺灣" || b != "臺灣" {
+		t.Fatalf("got %q %q", a, b)
+	}
+	// 简体字对应多个繁体字时取最常用的一个, 繁简相同的字保持不变
+	if got := s2t.Convert("钥匙只有一把"); got != "鑰匙只有一把" {
+		t.Fatalf("got %q", got)
+	}
+
+	a := &Analyzer{CharFilters: []CharFilter{t2s.CharFilter()}, Tokenizer: fieldsTokenizer{}}
+	text := "我 在 臺灣"
+	terms := a.Tokenzie(text, false)
+	if last := terms[len(terms)-1]; last.Text != "台湾" || text[last.Start:last.End] != "臺灣" {
+		t.Fatalf("got %+v", last)
+	}
+}
+
+func TestPinyinFilter(t *testing.T) {
+	terms := PinyinFilter{}.Filter([]Term{
+		{Text: "北京", End: 6, Pos: 0},
+		{Text: "abc", Start: 6, End: 9, Pos: 1},
+		{Text: "女", Start: 9, End: 12, Pos: 2},
+	})
+	want := []Term{
+		{Text: "beijing", End: 6, Pos: 0},
+		{Text: "bj", End: 6, Pos: 0},
+		{Text: "nv", Start: 9, End: 12, Pos: 2},
+	}
+	if !reflect.DeepEqual(terms, want) {
+		t.Fatalf("got %+v, want %+v", terms, want)
+	}
+
+	for q, want := range map[string]string{"Bei Jing": "beijing", "xi'an": "xian", "北京": "", "bj2": ""} {
+		if got, _ := pinyinQuery(q); got != want {
+			t.Fatalf("pinyinQuery(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestPinyin(t *testing.T) {
+	for text, want := range map[string]string{
+		"银行":   "yinhang",
+		"行长":   "hangzhang",
+		"银行行长": "yinhanghangzhang",
+		"重庆":   "chongqing",
+		"重要":   "zhongyao",
+		"深圳":   "shenzhen",
+		"廈門":   "xiamen",
+		"重慶":   "chongqing",
+		"邯鄲":   "handan",
+		"泸州":   "luzhou",
+		"蓉城":   "rongcheng",
+		"婷婷":   "tingting",
+		// 不在拼音表中的字符被跳过
+		"北京2008": "beijing",
+		"abc":    "",
+	} {
+		syllables, ok := Pinyin(text)
+		if got := strings.Join(syllables, ""); got != want || ok != (want != "") {
+			t.Fatalf("Pinyin(%q) = %q %v, want %q", text, got, ok, want)
+		}
+	}
+}
+
+func TestNGramTokenizer(t *testing.T) {
+	text := "张三丰用iPhone15, 在東京タワー 见"
+	var got []string
+	for _, term := range NewNGramTokenizer(2).Tokenzie(text, false) {
+		if text[term.Start:term.End] != term.Text {
+			t.Fatalf("bad offsets %+v", term)
+		}
+		got = append(got, term.Text)
+	}
+	want := []string{"张三", "三丰", "丰用", "iPhone15", "在東", "東京", "京タ", "タワ", "ワー", "见"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	m := MultiTokenizer{NewNGramTokenizer(2), fieldsTokenizer{}}
+	terms := analyze(m, "北京大学 go", false)
+	want2 := []Term{
+		{Text: "北京", Start: 0, End: 6, Pos: 0},
+		{Text: "北京大学", Start: 0, End: 12, Pos: 0},
+		{Text: "京大", Start: 3, End: 9, Pos: 1},
+		{Text: "大学", Start: 6, End: 12, Pos: 2},
+		{Text: "go", Start: 13, End: 15, Pos: 3},
+	}
+	if !reflect.DeepEqual(terms, want2) {
+		t.Fatalf("got %+v, want %+v", terms, want2)
+	}
+}
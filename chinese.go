@@ -0,0 +1,91 @@
+package tns
+
+import (
+	_ "embed"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChineseConvert 简繁转换的方向
+type ChineseConvert int
+
+const (
+	// TraditionalToSimplified 繁体转简体
+	TraditionalToSimplified ChineseConvert = iota
+	// SimplifiedToTraditional 简体转繁体. 一个简体字可能对应多个繁体字, 只取表中的第一个,
+	// 适合把索引与查询统一为繁体, 不适合用于展示
+	SimplifiedToTraditional
+)
+
+//go:embed zh_t2s.txt
+var zhT2S string
+
+// ChineseFilter 按字符做简繁转换, 使 "臺灣" 与 "台湾" 得到相同的词元. 索引与查询需要使用相同的方向.
+//
+// 作为 TokenFilter 转换分词结果; 分词器对繁体文本的切分与简体不同时, 使用 CharFilter 在分词前转换
+type ChineseFilter struct {
+	table map[rune]rune
+}
+
+func NewChineseFilter(conv ChineseConvert) *ChineseFilter {
+	f := &ChineseFilter{table: make(map[rune]rune)}
+	for _, line := range strings.Split(zhT2S, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		for _, pair := range strings.Fields(line) {
+			t, n := utf8.DecodeRuneInString(pair)
+			s, _ := utf8.DecodeRuneInString(pair[n:])
+			if conv == SimplifiedToTraditional {
+				t, s = s, t
+			}
+			if _, ok := f.table[t]; !ok {
+				f.table[t] = s
+			}
+		}
+	}
+	return f
+}
+
+// Convert 转换 text 中的每个字符
+func (f *ChineseFilter) Convert(text string) string {
+	return strings.Map(func(r rune) rune {
+		if c, ok := f.table[r]; ok {
+			return c
+		}
+		return r
+	}, text)
+}
+
+func (f *ChineseFilter) Filter(terms []Term) []Term {
+	for i := range terms {
+		terms[i].Text = f.Convert(terms[i].Text)
+	}
+	return terms
+}
+
+// CharFilter 返回在分词前做同样转换的字符过滤
+func (f *ChineseFilter) CharFilter() CharFilter {
+	return chineseCharFilter{f}
+}
+
+type chineseCharFilter struct {
+	f *ChineseFilter
+}
+
+func (c chineseCharFilter) Filter(text string, w *CharWriter) {
+	start := 0
+	for i, r := range text {
+		conv, ok := c.f.table[r]
+		if !ok {
+			continue
+		}
+
+		w.Write(text[start:i], start, i)
+		size := utf8.RuneLen(r)
+		w.Write(string(conv), i, i+size)
+		start = i + size
+	}
+	w.Write(text[start:], start, len(text))
+}
@@ -12,7 +12,12 @@ import (
 var (
 	store tns.Store
 	seg   sego.Segmenter
-	t     tns.Tokenizer = tns.NewJiebaTokenizer()
+	t     tns.Tokenizer = &tns.Analyzer{
+		CharFilters: []tns.CharFilter{tns.WikiStripFilter{}, tns.HTMLStripFilter{}, tns.WidthFilter{},
+			tns.NewChineseFilter(tns.TraditionalToSimplified).CharFilter()},
+		Tokenizer:    tns.MultiTokenizer{tns.NewNGramTokenizer(2), tns.NewJiebaTokenizer()},
+		TokenFilters: []tns.TokenFilter{tns.LowercaseFilter{}, tns.NewStopFilter()},
+	}
 )
 
 func main() {
@@ -26,7 +31,7 @@ func main() {
 
 	buildIndex(2000000)
 	// ii, store := loadIndex()
-	// searcher := tns.NewSearcher(ii, t, store)
+	// searcher, err := tns.NewSearcher(ii, t, store)
 
 	// r := bufio.NewReader(os.Stdin)
 	// for {
@@ -76,6 +81,11 @@ func loadIndex() (*tns.InvertIndex, tns.Store) {
 	}
 	//defer store.Close()
 
+	store, err = store.Index("wiki")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ii, err := tns.LoadInvertIndex(store)
 	if err != nil {
 		log.Fatal(err)
@@ -100,7 +110,23 @@ func buildIndex(total int) {
 	}
 	defer store.Close()
 
-	indexer := tns.NewIndexer(t, store)
+	err = store.CreateIndex(&tns.IndexSpec{
+		Name: "wiki",
+		Fields: []*tns.FieldSpec{
+			{Name: "Title", Type: tns.FieldText, Indexed: true, Stored: true, Positions: true, Offsets: true, Pinyin: true},
+			{Name: "Text", Type: tns.FieldText, Indexed: true, Stored: true, Positions: true, Offsets: true},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wiki, err := store.Index("wiki")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	indexer := tns.NewIndexer(t, wiki)
 
 	processed := 0
 	for p := range ch {
@@ -127,5 +153,7 @@ func buildIndex(total int) {
 	}
 
 	ii := indexer.Build()
-	ii.WriteTo(store)
+	if err := ii.WriteTo(wiki); err != nil {
+		log.Fatal(err)
+	}
 }
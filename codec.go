@@ -0,0 +1,166 @@
+package tns
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// posting list 的二进制编码格式 (ii bucket 的 value):
+//
+//	version(1 byte) | uvarint(len(Field)) | Field | uvarint(DocLen) | positions | offsets
+//
+// positions / offsets 的格式见 appendPositions / appendOffsets.
+// TokenID / DocID 已经在 key 中, 不再重复保存. 位置信息按增量编码后使用 varint 压缩.
+// 版本号 0x01 ~ 0x03 是开发中的格式, 不再支持. 旧版本使用 JSON 保存, 首字节总是 '{', 可以据此区分,
+// 其中的位置是字节偏移而不是词元序号, 无法还原, 读取时只保留词频 (与不记录位置的字段相同).
+const postingCodecVersion byte = 0x04
+
+var ErrBadPostingList = errors.New("bad posting list encoding")
+
+func encodePostingList(pl *PostingList) []byte {
+	buf := make([]byte, 1, 1+3*binary.MaxVarintLen64+len(pl.Field)+len(pl.PosList)*2)
+	buf[0] = postingCodecVersion
+
+	buf = binary.AppendUvarint(buf, uint64(len(pl.Field)))
+	buf = append(buf, pl.Field...)
+	buf = binary.AppendUvarint(buf, uint64(pl.DocLen))
+	buf = appendPositions(buf, pl.PosList)
+	buf = appendOffsets(buf, pl.Offsets)
+	return buf
+}
+
+func decodePostingList(v []byte, pl *PostingList) error {
+	if len(v) == 0 {
+		return ErrBadPostingList
+	}
+
+	switch v[0] {
+	case '{':
+		return decodeLegacyPostingList(v, pl)
+	case postingCodecVersion:
+	default:
+		return ErrBadPostingList
+	}
+
+	v = v[1:]
+	l, n := binary.Uvarint(v)
+	if n <= 0 || l > uint64(len(v)-n) {
+		return ErrBadPostingList
+	}
+	pl.Field = string(v[n : n+int(l)])
+	v = v[n+int(l):]
+
+	docLen, n := binary.Uvarint(v)
+	if n <= 0 {
+		return ErrBadPostingList
+	}
+	pl.DocLen = int(docLen)
+
+	v = v[n:]
+
+	pos, n, err := readPositions(v)
+	if err != nil {
+		return err
+	}
+	pl.PosList = pos
+
+	pl.Offsets, _, err = readOffsets(v[n:])
+	return err
+}
+
+// decodeLegacyPostingList 解码 JSON 格式的 posting, 字节偏移的位置改为 0
+func decodeLegacyPostingList(v []byte, pl *PostingList) error {
+	if err := json.Unmarshal(v, pl); err != nil {
+		return err
+	}
+
+	for i := range pl.PosList {
+		pl.PosList[i] = 0
+	}
+	return nil
+}
+
+// appendPositions 写入位置数量以及增量编码后的位置列表, 要求 pos 升序
+func appendPositions(buf []byte, pos []int) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(pos)))
+
+	prev := 0
+	for _, p := range pos {
+		buf = binary.AppendUvarint(buf, uint64(p-prev))
+		prev = p
+	}
+	return buf
+}
+
+// appendOffsets 写入偏移数量以及每个偏移: uvarint(Start 与前一个 Start 的差) | uvarint(End - Start),
+// 要求 Start 升序
+func appendOffsets(buf []byte, offsets []Offset) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(offsets)))
+
+	prev := 0
+	for _, o := range offsets {
+		buf = binary.AppendUvarint(buf, uint64(o.Start-prev))
+		buf = binary.AppendUvarint(buf, uint64(o.End-o.Start))
+		prev = o.Start
+	}
+	return buf
+}
+
+// readOffsets 与 appendOffsets 对应, 返回偏移列表以及读取的字节数
+func readOffsets(v []byte) ([]Offset, int, error) {
+	count, n := binary.Uvarint(v)
+	if n <= 0 || count > uint64(len(v)) {
+		return nil, 0, ErrBadPostingList
+	}
+	off := n
+
+	if count == 0 {
+		return nil, off, nil
+	}
+
+	offsets := make([]Offset, count)
+	prev := 0
+	for i := range offsets {
+		delta, n := binary.Uvarint(v[off:])
+		if n <= 0 {
+			return nil, 0, ErrBadPostingList
+		}
+		off += n
+
+		l, n := binary.Uvarint(v[off:])
+		if n <= 0 {
+			return nil, 0, ErrBadPostingList
+		}
+		off += n
+
+		prev += int(delta)
+		offsets[i] = Offset{Start: prev, End: prev + int(l)}
+	}
+
+	return offsets, off, nil
+}
+
+// readPositions 与 appendPositions 对应, 返回位置列表以及读取的字节数
+func readPositions(v []byte) ([]int, int, error) {
+	count, n := binary.Uvarint(v)
+	if n <= 0 || count > uint64(len(v)) {
+		return nil, 0, ErrBadPostingList
+	}
+	off := n
+
+	pos := make([]int, count)
+	prev := 0
+	for i := range pos {
+		delta, n := binary.Uvarint(v[off:])
+		if n <= 0 {
+			return nil, 0, ErrBadPostingList
+		}
+		off += n
+
+		prev += int(delta)
+		pos[i] = prev
+	}
+
+	return pos, off, nil
+}
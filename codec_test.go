@@ -0,0 +1,45 @@
+package tns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostingListCodec(t *testing.T) {
+	pl := &PostingList{Field: "Text", DocLen: 1024, PosList: []int{0, 3, 3, 200, 70000},
+		Offsets: []Offset{{0, 3}, {9, 15}, {9, 12}, {600, 606}, {210000, 210003}}}
+
+	got := &PostingList{}
+	if err := decodePostingList(encodePostingList(pl), got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, pl) {
+		t.Fatalf("decoded %+v, want %+v", got, pl)
+	}
+}
+
+func TestPostingListCodecJSON(t *testing.T) {
+	// 旧版本的位置是字节偏移, 只保留词频
+	v := []byte(`{"TokenID":1,"DocID":2,"DocLen":10,"PosList":[1,5,12]}`)
+
+	got := &PostingList{}
+	if err := decodePostingList(v, got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &PostingList{TokenID: 1, DocID: 2, DocLen: 10, PosList: []int{0, 0, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestPostingListCodecBad(t *testing.T) {
+	const ver = postingCodecVersion
+	// 0x01 ~ 0x03 是不再支持的旧格式
+	for _, v := range [][]byte{nil, {0x7f}, {0x01, 0, 1, 0, 0}, {0x03, 0, 1, 0, 0}, {ver + 1, 0, 1, 0, 0}, {ver}, {ver, 9, 'a'}, {ver, 0, 1, 1}, {ver, 0, 1, 1, 0, 2, 0}} {
+		if err := decodePostingList(v, &PostingList{}); err == nil {
+			t.Fatalf("expect error for %v", v)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package tns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Explanation 得分或匹配的解释树, Value 为该节点的值, Details 为计算该值用到的子项
+type Explanation struct {
+	Match       bool
+	Value       float64
+	Description string
+	Details     []*Explanation `json:",omitempty"`
+}
+
+func explainf(value float64, format string, args ...any) *Explanation {
+	return &Explanation{Match: true, Value: value, Description: fmt.Sprintf(format, args...)}
+}
+
+func noMatchf(format string, args ...any) *Explanation {
+	return &Explanation{Description: fmt.Sprintf(format, args...)}
+}
+
+// add 追加子项, 返回 e 本身
+func (e *Explanation) add(details ...*Explanation) *Explanation {
+	e.Details = append(e.Details, details...)
+	return e
+}
+
+// String 按缩进输出解释树, 每行为 "值 = 描述"
+func (e *Explanation) String() string {
+	var b strings.Builder
+	e.write(&b, 0)
+	return b.String()
+}
+
+func (e *Explanation) write(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(strconv.FormatFloat(e.Value, 'g', -1, 64))
+	b.WriteString(" = ")
+	b.WriteString(e.Description)
+	b.WriteByte('\n')
+	for _, d := range e.Details {
+		d.write(b, depth+1)
+	}
+}
+
+// Explain 解析查询并解释文档 docID 的得分, 文档不在前几名结果中时同样可用. opts 为 nil 时使用默认参数
+func (s *Searcher) Explain(q string, docID uint64, opts *SearchOptions) (*Explanation, error) {
+	query, err := s.ParseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.ExplainQuery(query, docID, opts)
+}
+
+// ExplainQuery 解释文档 docID 的得分. 文档命中时返回打分函数的解释,
+// 否则返回各查询节点的匹配情况, 说明文档为什么没有命中. opts 为 nil 时使用默认参数
+func (s *Searcher) ExplainQuery(query Query, docID uint64, opts *SearchOptions) (*Explanation, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+	allFields := s.allFields()
+
+	m, err := s.matcher(query, allFields)
+	if err != nil {
+		return nil, err
+	}
+	m.advance(docID)
+
+	var hits []*termHit
+	if m.docID() == docID {
+		hits = m.collect(nil)
+	}
+	if err := m.close(); err != nil {
+		return nil, err
+	}
+
+	if hits == nil {
+		match, err := s.explainMatch(query, "", docID, allFields)
+		if err != nil {
+			return nil, err
+		}
+		e := noMatchf("doc %d does not match %s", docID, query)
+		return e.add(match), nil
+	}
+
+	sim, err := s.similarity(opts.Score)
+	if err != nil {
+		return nil, err
+	}
+	ctx := s.scoreContext(opts)
+	ctx.Explain = true
+	_, e := sim.Score(&Hit{docID: docID, hitTokens: hits}, ctx)
+	return e, nil
+}
+
+// explainMatch 解释查询树中每个节点是否匹配文档 docID, 叶子节点的值为词频,
+// bool 节点的值为匹配的 must 与 should 子句数. prefix 为节点在上层 bool 查询中的 "+" / "-"
+func (s *Searcher) explainMatch(q Query, prefix string, docID uint64, allFields []string) (*Explanation, error) {
+	bq, ok := q.(*BoolQuery)
+	if !ok {
+		m, err := s.matcher(q, allFields)
+		if err != nil {
+			return nil, err
+		}
+		m.advance(docID)
+
+		var e *Explanation
+		if m.docID() == docID {
+			var freq float64
+			var details []*Explanation
+			for _, th := range m.collect(nil) {
+				freq += th.freq()
+				details = append(details, explainf(th.freq(), "freq of %s", th.name()))
+			}
+			e = explainf(freq, "%s%s matched", prefix, q).add(details...)
+		} else {
+			e = noMatchf("%s%s not matched", prefix, q)
+		}
+		return e, m.close()
+	}
+
+	var (
+		details       []*Explanation
+		mustMatched   int
+		shouldMatched int
+		reason        string
+	)
+	for _, g := range []struct {
+		prefix string
+		qs     []Query
+	}{{"+", bq.Must}, {"", bq.Should}, {"-", bq.MustNot}} {
+		for _, c := range g.qs {
+			e, err := s.explainMatch(c, g.prefix, docID, allFields)
+			if err != nil {
+				return nil, err
+			}
+
+			switch {
+			case g.prefix == "+" && e.Match:
+				mustMatched++
+			case g.prefix == "+" && reason == "":
+				reason = "missing required clause " + c.String()
+			case g.prefix == "" && e.Match:
+				shouldMatched++
+			case g.prefix == "-" && e.Match && reason == "":
+				reason = "prohibited clause matched " + c.String()
+			}
+			details = append(details, e)
+		}
+	}
+	if reason == "" && len(bq.Must) == 0 && shouldMatched == 0 {
+		reason = "no should clause matched"
+	}
+
+	matched := float64(mustMatched + shouldMatched)
+	if reason != "" {
+		e := noMatchf("%s(%s) not matched: %s", prefix, bq, reason)
+		e.Value = matched
+		return e.add(details...), nil
+	}
+	return explainf(matched, "%s(%s) matched, number of matched clauses:", prefix, bq).add(details...), nil
+}
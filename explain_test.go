@@ -0,0 +1,69 @@
+package tns
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExplanation(t *testing.T) {
+	e := explainf(1.5, "sum of:").add(
+		explainf(1, "weight(%s)", "Text:北京"),
+		explainf(0.5, "weight(%s)", "Title:北京").add(noMatchf("child")),
+	)
+
+	want := "1.5 = sum of:\n  1 = weight(Text:北京)\n  0.5 = weight(Title:北京)\n    0 = child\n"
+	if got := e.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	js, err := json.Marshal(e.Details[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"Match":true,"Value":0.5,"Description":"weight(Title:北京)","Details":[{"Match":false,"Value":0,"Description":"child"}]}`
+	if string(js) != want {
+		t.Fatalf("got %s, want %s", js, want)
+	}
+}
+
+func TestExplainScore(t *testing.T) {
+	s := testSearcher(t, testFields,
+		map[string]string{"Title": "a", "Text": "b c"},
+		map[string]string{"Title": "b", "Text": "c d b"},
+		map[string]string{"Title": "e", "Text": "f"},
+	)
+
+	for _, score := range []string{"", "bm25", "bm25f", "lm-dirichlet"} {
+		opts := &SearchOptions{Size: 10, Score: score}
+		hits, err := s.SearchWith("b c", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits.Hits) != 2 {
+			t.Fatalf("%s: got %d hits", score, len(hits.Hits))
+		}
+
+		for _, h := range hits.Hits {
+			eopts := opts
+			if score == "" {
+				// nil 与默认参数相同
+				eopts = nil
+			}
+			e, err := s.Explain("b c", h.Doc.ID, eopts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !e.Match || e.Value != h.Score {
+				t.Fatalf("%s: doc %d explain %v, score %v\n%s", score, h.Doc.ID, e.Value, h.Score, e)
+			}
+		}
+	}
+
+	e, err := s.Explain("b c", 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Match {
+		t.Fatalf("doc 3 matched:\n%s", e)
+	}
+}
@@ -0,0 +1,258 @@
+package tns
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// HTMLStripFilter 去掉 HTML 标签 (替换为空格) 并解码字符实体
+type HTMLStripFilter struct{}
+
+func (HTMLStripFilter) Filter(text string, w *CharWriter) {
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '<':
+			if j := strings.IndexByte(text[i:], '>'); j > 0 {
+				w.Write(" ", i, i+j+1)
+				i += j + 1
+				continue
+			}
+		case '&':
+			if j := strings.IndexByte(text[i:], ';'); j > 1 && j <= 10 {
+				ent := text[i : i+j+1]
+				if s := html.UnescapeString(ent); s != ent {
+					w.Write(s, i, i+j+1)
+					i += j + 1
+					continue
+				}
+			}
+		}
+
+		j := i + 1 + strings.IndexAny(text[i+1:], "<&")
+		if j == i {
+			j = len(text)
+		}
+		w.Write(text[i:j], i, j)
+		i = j
+	}
+}
+
+// WikiStripFilter 去掉 wiki 标记: 模板 {{...}} 与文件/分类链接被删除, [[目标|文本]] 与 [url 文本] 只保留文本,
+// 加粗/斜体的引号与标题的等号被删除
+type WikiStripFilter struct{}
+
+// wikiDropLinks 整个删除的内部链接前缀
+var wikiDropLinks = []string{"File:", "Image:", "Category:", "文件:", "图像:", "分类:"}
+
+func wikiDropLink(target string) bool {
+	for _, p := range wikiDropLinks {
+		if strings.HasPrefix(target, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (WikiStripFilter) Filter(text string, w *CharWriter) {
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		switch {
+		case strings.HasPrefix(rest, "{{"):
+			n := wikiSpan(rest, "{{", "}}")
+			w.Write(" ", i, i+n)
+			i += n
+			continue
+		case strings.HasPrefix(rest, "[["):
+			n := wikiSpan(rest, "[[", "]]")
+			inner := strings.TrimSuffix(rest[2:n], "]]")
+			if wikiDropLink(inner) {
+				w.Write(" ", i, i+n)
+			} else {
+				start, end := i+2, i+2+len(inner)
+				if k := strings.LastIndexByte(inner, '|'); k >= 0 {
+					start += k + 1
+				}
+				w.Write(text[start:end], start, end)
+			}
+			i += n
+			continue
+		case strings.HasPrefix(rest, "[http") || strings.HasPrefix(rest, "[//"):
+			if j := strings.IndexByte(rest, ']'); j > 0 {
+				if k := strings.IndexByte(rest[:j], ' '); k > 0 {
+					w.Write(text[i+k+1:i+j], i+k+1, i+j)
+				} else {
+					w.Write(" ", i, i+j+1)
+				}
+				i += j + 1
+				continue
+			}
+		case strings.HasPrefix(rest, "''"), strings.HasPrefix(rest, "=="):
+			n := 2
+			for n < len(rest) && rest[n] == rest[0] {
+				n++
+			}
+			w.Write(" ", i, i+n)
+			i += n
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		w.Write(rest[:size], i, i+size)
+		i += size
+	}
+}
+
+// wikiSpan 返回 s 开头由 open / close 包围的 (可嵌套) 片段的长度, 没有闭合时返回 len(s)
+func wikiSpan(s, open, close string) int {
+	depth := 0
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], open):
+			depth++
+			i += len(open)
+		case strings.HasPrefix(s[i:], close):
+			depth--
+			i += len(close)
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(s)
+}
+
+// WidthFilter 将全角 ASCII 字符与全角空格转换为半角
+type WidthFilter struct{}
+
+func (WidthFilter) Filter(text string, w *CharWriter) {
+	start := 0
+	for i, r := range text {
+		var half rune
+		switch {
+		case r >= 0xFF01 && r <= 0xFF5E:
+			half = r - 0xFEE0
+		case r == 0x3000:
+			half = ' '
+		default:
+			continue
+		}
+
+		w.Write(text[start:i], start, i)
+		w.Write(string(half), i, i+utf8.RuneLen(r))
+		start = i + utf8.RuneLen(r)
+	}
+	w.Write(text[start:], start, len(text))
+}
+
+// LowercaseFilter 将词元转换为小写
+type LowercaseFilter struct{}
+
+func (LowercaseFilter) Filter(terms []Term) []Term {
+	for i := range terms {
+		terms[i].Text = strings.ToLower(terms[i].Text)
+	}
+	return terms
+}
+
+// StopFilter 删除停用词以及空白词元, 被删除的词元留下位置空缺, 短语查询仍然按原位置匹配
+type StopFilter map[string]bool
+
+func NewStopFilter(words ...string) StopFilter {
+	f := make(StopFilter)
+	for _, w := range words {
+		f[w] = true
+	}
+	return f
+}
+
+func (f StopFilter) Filter(terms []Term) []Term {
+	out := terms[:0]
+	for _, t := range terms {
+		if !f[t.Text] && strings.TrimSpace(t.Text) != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// LengthFilter 删除字符数小于 Min 或大于 Max 的词元, Max 为 0 时不限制最大长度
+type LengthFilter struct {
+	Min int
+	Max int
+}
+
+func (f LengthFilter) Filter(terms []Term) []Term {
+	out := terms[:0]
+	for _, t := range terms {
+		n := utf8.RuneCountInString(t.Text)
+		if n >= f.Min && (f.Max <= 0 || n <= f.Max) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// SynonymFilter 在词元之后插入它的同义词, 同义词与原词的位置与偏移相同.
+// 只支持单个词元的同义词
+type SynonymFilter map[string][]string
+
+// NewSynonymFilter 每组中的词互为同义词
+func NewSynonymFilter(groups ...[]string) SynonymFilter {
+	f := make(SynonymFilter)
+	for _, g := range groups {
+		for _, w := range g {
+			for _, syn := range g {
+				if syn != w {
+					f[w] = append(f[w], syn)
+				}
+			}
+		}
+	}
+	return f
+}
+
+func (f SynonymFilter) Filter(terms []Term) []Term {
+	var out []Term
+	for _, t := range terms {
+		out = append(out, t)
+		for _, syn := range f[t.Text] {
+			s := t
+			s.Text = syn
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// KeywordFilter 只保留文本中最重要的 TopK 个关键词, 其余词元留下位置空缺.
+// 关键词由 Extract 从词元以空格拼接成的文本中提取, 避免相邻的英文单词粘连, 例如 JiebaTokenizer.KeywordFilter
+type KeywordFilter struct {
+	TopK    int
+	Extract func(text string, topK int) []string
+}
+
+func (f *KeywordFilter) Filter(terms []Term) []Term {
+	var b strings.Builder
+	for i, t := range terms {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.Text)
+	}
+
+	keywords := make(map[string]bool)
+	for _, w := range f.Extract(b.String(), f.TopK) {
+		keywords[w] = true
+	}
+
+	out := terms[:0]
+	for _, t := range terms {
+		if keywords[t.Text] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package tns
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// HighlightOptions 高亮参数
+type HighlightOptions struct {
+	// Fields 需要高亮的字段, 为空时高亮所有命中的字段
+	Fields []string
+	// PreTag / PostTag 包裹命中词元的标签, 默认为 <em> 和 </em>. 原文不做转义
+	PreTag  string
+	PostTag string
+	// FragmentSize 片段的长度 (字符数), 默认为 100. 片段不会截断命中的词元
+	FragmentSize int
+	// MaxFragments 每个字段最多返回的片段数, 默认为 3
+	MaxFragments int
+}
+
+var DefaultHighlight = HighlightOptions{
+	PreTag:       "<em>",
+	PostTag:      "</em>",
+	FragmentSize: 100,
+	MaxFragments: 3,
+}
+
+func (o *HighlightOptions) withDefaults() *HighlightOptions {
+	opts := *o
+	if opts.PreTag == "" && opts.PostTag == "" {
+		opts.PreTag, opts.PostTag = DefaultHighlight.PreTag, DefaultHighlight.PostTag
+	}
+	if opts.FragmentSize <= 0 {
+		opts.FragmentSize = DefaultHighlight.FragmentSize
+	}
+	if opts.MaxFragments <= 0 {
+		opts.MaxFragments = DefaultHighlight.MaxFragments
+	}
+	return &opts
+}
+
+// highlightHit 返回字段名 -> 高亮片段. 优先使用 posting 中保存的字节偏移,
+// 没有偏移时重新切分保存的字段值, 查找命中的词元. 拼音子字段的命中高亮在原字段上
+func (s *Searcher) highlightHit(h *Hit, opts *HighlightOptions) map[string][]string {
+	byField := make(map[string][]*termHit)
+	for _, th := range h.hitTokens {
+		field := strings.TrimSuffix(th.field, PinyinSuffix)
+		byField[field] = append(byField[field], th)
+	}
+
+	result := make(map[string][]string)
+	for field, ths := range byField {
+		if len(opts.Fields) > 0 && !containsString(opts.Fields, field) {
+			continue
+		}
+
+		text, ok := h.Doc.Fields[field]
+		if !ok {
+			continue
+		}
+
+		var offsets []Offset
+		// values 按 (子) 字段记录需要重新查找的词元
+		values := make(map[string]map[string]bool)
+		for _, th := range ths {
+			if len(th.offsets) > 0 {
+				offsets = append(offsets, th.offsets...)
+				continue
+			}
+
+			if values[th.field] == nil {
+				values[th.field] = make(map[string]bool)
+			}
+			if th.phraseFreq > 0 {
+				terms, _ := s.analyze(s.spec, th.field, th.t.Value, false)
+				for _, term := range terms {
+					values[th.field][term.Text] = true
+				}
+			} else {
+				values[th.field][th.t.Value] = true
+			}
+		}
+
+		for sub, vs := range values {
+			terms, _ := s.analyze(s.spec, sub, text, false)
+			for _, term := range terms {
+				if vs[term.Text] {
+					offsets = append(offsets, Offset{Start: term.Start, End: term.end()})
+				}
+			}
+		}
+
+		if frags := highlight(text, offsets, opts); len(frags) > 0 {
+			result[field] = frags
+		}
+	}
+
+	return result
+}
+
+type fragment struct {
+	start, end int
+	matches    []Offset
+	distinct   int
+}
+
+// highlight 将 text 切分为长度约为 FragmentSize 的片段, 按片段中不同命中词元的数量以及命中次数
+// 选出最好的 MaxFragments 个片段, 并用标签包裹命中的词元
+func highlight(text string, matches []Offset, opts *HighlightOptions) []string {
+	matches = normalizeOffsets(matches, text)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var frags []*fragment
+	mi := 0
+	for start := 0; start < len(text) && mi < len(matches); {
+		end := start
+		for n := 0; n < opts.FragmentSize && end < len(text); n++ {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+
+		f := &fragment{start: start}
+		for ; mi < len(matches) && matches[mi].Start < end; mi++ {
+			if matches[mi].End > end {
+				end = matches[mi].End
+			}
+			f.matches = append(f.matches, matches[mi])
+		}
+		f.end = end
+
+		if len(f.matches) > 0 {
+			seen := make(map[string]bool)
+			for _, m := range f.matches {
+				seen[text[m.Start:m.End]] = true
+			}
+			f.distinct = len(seen)
+			frags = append(frags, f)
+		}
+		start = end
+	}
+
+	sort.SliceStable(frags, func(i, j int) bool {
+		if frags[i].distinct != frags[j].distinct {
+			return frags[i].distinct > frags[j].distinct
+		}
+		return len(frags[i].matches) > len(frags[j].matches)
+	})
+	if len(frags) > opts.MaxFragments {
+		frags = frags[:opts.MaxFragments]
+	}
+
+	result := make([]string, len(frags))
+	for i, f := range frags {
+		var b strings.Builder
+		prev := f.start
+		for _, m := range f.matches {
+			b.WriteString(text[prev:m.Start])
+			b.WriteString(opts.PreTag)
+			b.WriteString(text[m.Start:m.End])
+			b.WriteString(opts.PostTag)
+			prev = m.End
+		}
+		b.WriteString(text[prev:f.end])
+		result[i] = strings.TrimSpace(b.String())
+	}
+	return result
+}
+
+// normalizeOffsets 排序并合并重叠的偏移, 丢弃超出 text 或不在字符边界上的偏移
+func normalizeOffsets(offsets []Offset, text string) []Offset {
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].Start < offsets[j].Start })
+
+	var result []Offset
+	for _, o := range offsets {
+		if o.Start < 0 || o.End > len(text) || o.Start >= o.End {
+			continue
+		}
+		if !utf8.RuneStart(text[o.Start]) || (o.End < len(text) && !utf8.RuneStart(text[o.End])) {
+			continue
+		}
+
+		if l := len(result); l > 0 && o.Start < result[l-1].End {
+			if o.End > result[l-1].End {
+				result[l-1].End = o.End
+			}
+			continue
+		}
+		result = append(result, o)
+	}
+	return result
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
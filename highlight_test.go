@@ -0,0 +1,84 @@
+package tns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	text := "北京 是 首都. 上海 很 大, 上海 有 地铁. 北京 也 有 地铁"
+	find := func(words ...string) []Offset {
+		var offsets []Offset
+		for _, w := range words {
+			for i := 0; i+len(w) <= len(text); i++ {
+				if text[i:i+len(w)] == w {
+					offsets = append(offsets, Offset{i, i + len(w)})
+				}
+			}
+		}
+		return offsets
+	}
+
+	opts := (&HighlightOptions{FragmentSize: 12, MaxFragments: 2}).withDefaults()
+	got := highlight(text, find("北京", "地铁"), opts)
+	want := []string{". <em>北京</em> 也 有 <em>地铁</em>", "<em>北京</em> 是 首都. 上海"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// 命中的词元跨越片段边界时片段延长, 重叠的偏移合并
+	opts = (&HighlightOptions{FragmentSize: 1, MaxFragments: 1, PreTag: "[", PostTag: "]"}).withDefaults()
+	got = highlight("abcdef", []Offset{{1, 3}, {2, 4}, {10, 12}}, opts)
+	if want := []string{"[bcd]"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if got := highlight(text, nil, opts); got != nil {
+		t.Fatalf("got %q, want nil", got)
+	}
+}
+
+func TestNormalizeOffsets(t *testing.T) {
+	// 北 京 各 3 个字节
+	text := "北京ab"
+	got := normalizeOffsets([]Offset{{6, 8}, {1, 3}, {0, 4}, {3, 6}, {-1, 2}, {7, 9}, {7, 7}, {0, 3}}, text)
+	want := []Offset{{0, 3}, {3, 6}, {6, 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// 偏移不在字符边界上时不高亮, 不会切断字符
+	opts := (&HighlightOptions{PreTag: "[", PostTag: "]"}).withDefaults()
+	if got := highlight(text, []Offset{{1, 6}, {3, 5}}, opts); got != nil {
+		t.Fatalf("got %q, want nil", got)
+	}
+}
+
+func TestSearchHighlight(t *testing.T) {
+	// HTMLStripFilter 改变了偏移: Title 使用索引中保存的偏移, Text 没有保存偏移, 高亮时重新分析原文
+	html := &Analyzer{CharFilters: []CharFilter{HTMLStripFilter{}}, Tokenizer: fieldsTokenizer{}}
+	fields := []*FieldSpec{
+		{Name: "Title", Type: FieldText, Indexed: true, Stored: true, Positions: true, Offsets: true, Analyzer: "html"},
+		{Name: "Text", Type: FieldText, Indexed: true, Stored: true, Positions: true, Analyzer: "html"},
+	}
+	s := testAnalyzerSearcher(t, fields, map[string]Tokenizer{"html": html},
+		map[string]string{"Title": "<b>go</b> &amp; rust", "Text": "<p>learn <i>go</i> &lt;now&gt; go</p>"},
+		map[string]string{"Title": "java", "Text": "c"},
+	)
+
+	hits, err := s.SearchWith("go", &SearchOptions{Size: 10, Highlight: &HighlightOptions{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits.Hits) != 1 {
+		t.Fatalf("got %d hits", len(hits.Hits))
+	}
+
+	want := map[string][]string{
+		"Title": {"<b><em>go</em></b> &amp; rust"},
+		"Text":  {"<p>learn <i><em>go</em></i> &lt;now&gt; <em>go</em></p>"},
+	}
+	if got := hits.Hits[0].Highlights; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -1,12 +1,128 @@
 package tns
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IndexSpec 索引的 schema, 按索引名保存在 store 中
 type IndexSpec struct {
 	Name   string
 	Fields []*FieldSpec
+
+	// BM25 索引默认的 BM25 参数, 为 nil 时使用 DefaultBM25
+	BM25 *BM25Params `json:",omitempty"`
+	// BM25F 索引默认的字段权重等参数, 为 nil 时使用 DefaultBM25F
+	BM25F *BM25FParams `json:",omitempty"`
 }
 
+type FieldType string
+
+const (
+	FieldText    FieldType = "text"
+	FieldKeyword FieldType = "keyword"
+	FieldInteger FieldType = "integer"
+	FieldFloat   FieldType = "float"
+	FieldDate    FieldType = "date" // RFC 3339
+	FieldBool    FieldType = "bool"
+)
+
 type FieldSpec struct {
 	Name string
+	Type FieldType
+
+	// Indexed 是否建立倒排索引
+	Indexed bool
+	// Stored 是否保存原始值, GetDoc 只返回保存的字段
+	Stored bool
+	// Positions 是否记录词元位置 (词元序号), 不记录时只保留词频
+	Positions bool
+	// Offsets 是否记录词元在原文中的字节偏移, 用于高亮
+	Offsets bool
+	// Analyzer 文本字段使用的分词器名称, 为空时使用 Indexer 默认的分词器
+	Analyzer string
+	// Pinyin 是否把文本字段中汉字词元的拼音写入子字段 Name + PinyinSuffix, 用于拼音搜索
+	Pinyin bool
+}
+
+var (
+	ErrIndexNotFound = errors.New("index not found")
+	ErrIndexClosed   = errors.New("index closed")
+	ErrIndexExists   = errors.New("index already exists")
+	ErrBadIndexSpec  = errors.New("bad index spec")
+)
+
+// Field 返回名为 name 的字段定义, 不存在时返回 nil
+func (s *IndexSpec) Field(name string) *FieldSpec {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Check 检查 schema 本身是否合法
+func (s *IndexSpec) Check() error {
+	if s.Name == "" {
+		return fmt.Errorf("%w: empty index name", ErrBadIndexSpec)
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range s.Fields {
+		if f.Name == "" || seen[f.Name] {
+			return fmt.Errorf("%w: empty or duplicated field name %q", ErrBadIndexSpec, f.Name)
+		}
+		if strings.HasSuffix(f.Name, PinyinSuffix) {
+			return fmt.Errorf("%w: field name %q is reserved for pinyin", ErrBadIndexSpec, f.Name)
+		}
+		seen[f.Name] = true
+
+		switch f.Type {
+		case FieldText:
+		case FieldKeyword, FieldInteger, FieldFloat, FieldDate, FieldBool:
+			if f.Analyzer != "" || f.Pinyin {
+				return fmt.Errorf("%w: analyzer or pinyin on %s field %q", ErrBadIndexSpec, f.Type, f.Name)
+			}
+		default:
+			return fmt.Errorf("%w: unknown type %q of field %q", ErrBadIndexSpec, f.Type, f.Name)
+		}
+	}
+
+	return nil
+}
+
+// Validate 检查文档是否符合 schema, 拒绝未定义的字段以及类型不匹配的值
+func (s *IndexSpec) Validate(doc *Document) error {
+	for name, val := range doc.Fields {
+		f := s.Field(name)
+		if f == nil {
+			return fmt.Errorf("index %s: unknown field %q", s.Name, name)
+		}
+
+		if err := f.check(val); err != nil {
+			return fmt.Errorf("index %s: field %q: %v", s.Name, name, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *FieldSpec) check(val string) (err error) {
+	switch f.Type {
+	case FieldInteger:
+		_, err = strconv.ParseInt(val, 10, 64)
+	case FieldFloat:
+		_, err = strconv.ParseFloat(val, 64)
+	case FieldDate:
+		_, err = time.Parse(time.RFC3339, val)
+	case FieldBool:
+		_, err = strconv.ParseBool(val)
+	}
+	return err
 }
 
 type Document struct {
@@ -19,17 +135,49 @@ type InvertedIndex struct {
 	h map[string]Token
 }
 
+// Token 词元, 同一个词在不同字段中是不同的词元
 type Token struct {
 	ID       uint64
+	Field    string
 	Value    string
 	DocCount int
 	PosCount int
+
+	// MaxFreq / MinDocLen 所有 posting 中最大的词频与最短的字段长度, 用于计算打分上界.
+	// 删除文档后不回退, 仍然是合法的上界. 旧版本的词元为 0, 表示没有上界
+	MaxFreq   int `json:",omitempty"`
+	MinDocLen int `json:",omitempty"`
 }
 
 // PostList 记录某个词元 (token) 在某个文档(DocID) 中的位置信息
 type PostingList struct {
 	TokenID uint64
 	DocID   uint64
-	DocLen  int
-	PosList []int // Freq = len(PosList)
+	Field   string
+	DocLen  int   // 字段长度 (位置数, 同一位置的多个词元只计一次)
+	PosList []int // 词元序号, Freq = len(PosList)
+
+	// Offsets 与 PosList 一一对应的字节偏移, 字段未开启 Offsets 时为空
+	Offsets []Offset `json:",omitempty"`
+}
+
+// Offset 词元在原文中的字节偏移 [Start, End)
+type Offset struct {
+	Start int
+	End   int
+}
+
+// FieldStats 字段在索引中的统计信息
+type FieldStats struct {
+	Name     string
+	DocCount int   // 包含该字段的文档数
+	TotalLen int64 // 该字段在所有文档中的长度 (位置数) 之和
+}
+
+// AvgLen 字段的平均长度
+func (fs *FieldStats) AvgLen() float64 {
+	if fs.DocCount == 0 {
+		return 0
+	}
+	return float64(fs.TotalLen) / float64(fs.DocCount)
 }
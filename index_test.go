@@ -0,0 +1,62 @@
+package tns_test
+
+import (
+	"testing"
+
+	"github.com/zhaoyao/tns"
+)
+
+func TestIndexSpecValidate(t *testing.T) {
+	spec := &tns.IndexSpec{
+		Name: "wiki",
+		Fields: []*tns.FieldSpec{
+			{Name: "Title", Type: tns.FieldText, Indexed: true, Stored: true},
+			{Name: "Views", Type: tns.FieldInteger, Stored: true},
+			{Name: "Score", Type: tns.FieldFloat},
+			{Name: "Updated", Type: tns.FieldDate},
+			{Name: "Redirect", Type: tns.FieldBool},
+		},
+	}
+
+	if err := spec.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]string{
+		"Title":    "北京",
+		"Views":    "42",
+		"Score":    "0.5",
+		"Updated":  "2018-01-02T15:04:05Z",
+		"Redirect": "false",
+	}
+	if err := spec.Validate(&tns.Document{Fields: valid}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fields := range []map[string]string{
+		{"Body": "unknown field"},
+		{"Views": "many"},
+		{"Score": "high"},
+		{"Updated": "yesterday"},
+		{"Redirect": "maybe"},
+	} {
+		if err := spec.Validate(&tns.Document{Fields: fields}); err == nil {
+			t.Fatalf("expect error for %v", fields)
+		}
+	}
+}
+
+func TestIndexSpecCheck(t *testing.T) {
+	for _, spec := range []*tns.IndexSpec{
+		{},
+		{Name: "a", Fields: []*tns.FieldSpec{{Name: "x", Type: "blob"}}},
+		{Name: "a", Fields: []*tns.FieldSpec{{Name: "x", Type: tns.FieldText}, {Name: "x", Type: tns.FieldText}}},
+		{Name: "a", Fields: []*tns.FieldSpec{{Name: "x", Type: tns.FieldKeyword, Analyzer: "jieba"}}},
+		{Name: "a", Fields: []*tns.FieldSpec{{Name: "x", Type: tns.FieldKeyword, Pinyin: true}}},
+		{Name: "a", Fields: []*tns.FieldSpec{{Name: "x" + tns.PinyinSuffix, Type: tns.FieldText}}},
+	} {
+		if err := spec.Check(); err == nil {
+			t.Fatalf("expect error for %+v", spec)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package tns
 import (
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
@@ -21,44 +23,222 @@ var (
 type Indexer struct {
 	//seg   *sego.Segmenter
 	//	jieba *gojieba.Jieba
-	t     Tokenizer
+	analysis
 	store Store
 
+	// specs 索引 schema 缓存, 值为 nil 表示该索引没有 schema
+	specs map[string]*IndexSpec
+
+	// tokenMap 以 tokenKey(field, value) 为 key
 	tokenMap map[string]*Token
+	// fields 字段统计信息, 第一次使用时从 store 加载
+	fields map[string]*FieldStats
 
 	// invert index map tokenID -> (docID, postingList)
 	iiMap map[uint64]map[uint64]*PostingList
 
 	count          int64
 	totalDocLength int64
+
+	// MergePolicy 后台段合并策略, 为 nil 时不合并
+	MergePolicy *MergePolicy
+	merging     int32
+	mergeWg     sync.WaitGroup
 }
 
 func NewIndexer(t Tokenizer, store Store) *Indexer {
+	policy := DefaultMergePolicy
 	return &Indexer{
-		t:        t,
-		store:    store,
-		tokenMap: make(map[string]*Token),
-		iiMap:    make(map[uint64]map[uint64]*PostingList),
+		analysis:    newAnalysis(t),
+		store:       store,
+		specs:       make(map[string]*IndexSpec),
+		tokenMap:    make(map[string]*Token),
+		iiMap:       make(map[uint64]map[uint64]*PostingList),
+		MergePolicy: &policy,
+	}
+}
+
+// PutIndexSpec 保存索引的 schema, 之后写入该索引的文档需要符合 schema
+func (i *Indexer) PutIndexSpec(spec *IndexSpec) error {
+	for _, f := range spec.Fields {
+		if _, ok := i.analyzers[f.Analyzer]; f.Analyzer != "" && !ok {
+			return fmt.Errorf("%w: unknown analyzer %q of field %q", ErrBadIndexSpec, f.Analyzer, f.Name)
+		}
+	}
+
+	if err := i.store.PutIndexSpec(spec); err != nil {
+		return err
+	}
+
+	i.specs[spec.Name] = spec
+	return nil
+}
+
+func (i *Indexer) indexSpec(name string) (*IndexSpec, error) {
+	if spec, ok := i.specs[name]; ok {
+		return spec, nil
+	}
+
+	spec, err := i.store.GetIndexSpec(name)
+	if err == ErrIndexNotFound {
+		spec, err = nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	i.specs[name] = spec
+	return spec, nil
+}
+
+// storedDoc 返回只包含 schema 中 Stored 字段的文档, 没有 schema 时保存全部字段
+func storedDoc(spec *IndexSpec, doc *Document) *Document {
+	if spec == nil {
+		return doc
+	}
+
+	stored := &Document{
+		Index:  doc.Index,
+		Fields: make(map[string]string, len(doc.Fields)),
+	}
+	for name, val := range doc.Fields {
+		if spec.Field(name).Stored {
+			stored.Fields[name] = val
+		}
+	}
+	return stored
+}
+
+// docSpec 检查文档所属的索引并返回该索引的 schema
+func (i *Indexer) docSpec(doc *Document) (*IndexSpec, error) {
+	name := i.store.IndexName()
+	if doc.Index == "" {
+		doc.Index = name
+	}
+	if doc.Index != name {
+		return nil, fmt.Errorf("document of index %q can not be added to index %q", doc.Index, name)
+	}
+
+	spec, err := i.indexSpec(doc.Index)
+	if err != nil {
+		return nil, err
+	}
+	if spec != nil {
+		if err := spec.Validate(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	return spec, nil
 }
 
 func (i *Indexer) AddDoc(doc *Document) (err error) {
+	spec, err := i.docSpec(doc)
+	if err != nil {
+		return err
+	}
+
 	start := time.Now()
-	err = i.store.AddDoc(doc)
+	stored := storedDoc(spec, doc)
+	err = i.store.AddDoc(stored)
 	if err != nil {
 		return err
 	}
+	doc.ID = stored.ID
 	AddDocTimer.UpdateSince(start)
 
-	for _, val := range doc.Fields {
+	return i.indexDoc(spec, doc)
+}
+
+// UpsertDoc 以外部 key (例如 wiki 页面标题) 写入文档. key 已存在时, 旧文档在存储层被原子地
+// 替换, 其词元统计与内存中的 posting 也一并移除.
+func (i *Indexer) UpsertDoc(key string, doc *Document) error {
+	spec, err := i.docSpec(doc)
+	if err != nil {
+		return err
+	}
+
+	var oldTerms []*fieldTerms
+	if id, err := i.store.LookupDoc(key); err == nil {
+		old, err := i.store.GetDoc(id)
+		if err != nil && err != ErrDocNotFound {
+			return err
+		}
+		if old != nil {
+			if oldTerms, err = i.docFieldTerms(id, old); err != nil {
+				return err
+			}
+		}
+	} else if err != ErrDocNotFound {
+		return err
+	}
+
+	stored := storedDoc(spec, doc)
+	oldID, err := i.store.upsertDoc(key, stored)
+	if err != nil {
+		return err
+	}
+	doc.ID = stored.ID
+
+	if oldID != 0 {
+		if err := i.removeDocTerms(oldID, oldTerms); err != nil {
+			return err
+		}
+	}
+
+	return i.indexDoc(spec, doc)
+}
+
+func (i *Indexer) indexDoc(spec *IndexSpec, doc *Document) error {
+	// 未保存的字段无法在删除时重新分析, 需要单独记录词频
+	var unstored []*fieldTerms
+
+	for name, val := range doc.Fields {
 		i.totalDocLength += int64(len(val))
-		if err := i.addTextToPosting(doc.ID, val); err != nil {
+
+		// 拼音子字段使用原字段的定义
+		var f *FieldSpec
+		if spec != nil {
+			f = spec.Field(name)
+		}
+
+		for _, field := range indexedFields(spec, name) {
+			terms, err := i.analyze(spec, field, val, false)
+			if err != nil {
+				return err
+			}
+			if len(terms) == 0 {
+				continue
+			}
+
+			ft := newFieldTerms(field, terms)
+			if f != nil && !f.Stored {
+				unstored = append(unstored, ft)
+			}
+
+			fs, err := i.fieldStats(field)
+			if err != nil {
+				return err
+			}
+			fs.DocCount++
+			fs.TotalLen += ft.Len
+
+			if err := i.addTermsToPosting(doc.ID, field, f, terms); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(unstored) > 0 {
+		if err := i.store.putDocTerms(doc.ID, unstored); err != nil {
 			return err
 		}
 	}
 
 	if len(i.iiMap) >= TokenPostingListKeptInMemory {
-		i.flushPostingList()
+		if err := i.flushPostingList(); err != nil {
+			return err
+		}
 	}
 
 	//AddDocTimer.UpdateSince(start)
@@ -67,40 +247,194 @@ func (i *Indexer) AddDoc(doc *Document) (err error) {
 	return nil
 }
 
+// DelDoc 删除文档: 存储层记录 tombstone, 同时修正文档中词元的 DocCount / PosCount 与字段统计,
+// 并丢弃内存中尚未写入段文件的 posting. 删除文档只能通过 Indexer, 存储层无法还原统计信息
+func (i *Indexer) DelDoc(id uint64) error {
+	doc, err := i.store.GetDoc(id)
+	if err != nil {
+		return err
+	}
+	fts, err := i.docFieldTerms(id, doc)
+	if err != nil {
+		return err
+	}
+
+	if err := i.store.delDoc(id); err != nil {
+		return err
+	}
+
+	return i.removeDocTerms(id, fts)
+}
+
+// fieldTerms 文档中一个字段的词频与长度
+type fieldTerms struct {
+	Field string         `json:"f"`
+	Len   int64          `json:"l"`
+	Freqs map[string]int `json:"t"`
+}
+
+func newFieldTerms(field string, terms []Term) *fieldTerms {
+	ft := &fieldTerms{Field: field, Len: int64(fieldLen(terms)), Freqs: make(map[string]int)}
+	for _, term := range terms {
+		ft.Freqs[term.Text]++
+	}
+	return ft
+}
+
+// fieldLen 字段长度: 不同位置的数量. 同义词, 拼音以及 MultiTokenizer 在同一位置产生的多个词元只计一次
+func fieldLen(terms []Term) int {
+	pos := make(map[int]bool, len(terms))
+	for _, term := range terms {
+		pos[term.Pos] = true
+	}
+	return len(pos)
+}
+
+// docFieldTerms 返回文档各字段的词频: 保存的字段重新分析, 未保存的字段读取索引时记录的词频.
+// 需要在存储层删除文档之前调用
+func (i *Indexer) docFieldTerms(id uint64, doc *Document) ([]*fieldTerms, error) {
+	spec, err := i.indexSpec(doc.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	var fts []*fieldTerms
+	for name, val := range doc.Fields {
+		for _, field := range indexedFields(spec, name) {
+			terms, err := i.analyze(spec, field, val, false)
+			if err != nil {
+				return nil, err
+			}
+			if len(terms) > 0 {
+				fts = append(fts, newFieldTerms(field, terms))
+			}
+		}
+	}
+
+	unstored, err := i.store.docTerms(id)
+	if err != nil {
+		return nil, err
+	}
+	return append(fts, unstored...), nil
+}
+
+// removeDocTerms 从词元与字段统计中减去文档的词频, 并丢弃内存中该文档的 posting
+func (i *Indexer) removeDocTerms(id uint64, fts []*fieldTerms) error {
+	for _, ft := range fts {
+		fs, err := i.fieldStats(ft.Field)
+		if err != nil {
+			return err
+		}
+		if fs.DocCount > 0 {
+			fs.DocCount--
+		}
+		fs.TotalLen -= ft.Len
+		if fs.TotalLen < 0 {
+			fs.TotalLen = 0
+		}
+
+		for text, freq := range ft.Freqs {
+			t, err := i.lookupToken(ft.Field, text)
+			if err != nil {
+				return err
+			}
+
+			if t.DocCount > 0 {
+				t.DocCount--
+			}
+			t.PosCount -= freq
+			if t.PosCount < 0 {
+				t.PosCount = 0
+			}
+
+			if plMap, ok := i.iiMap[t.ID]; ok {
+				delete(plMap, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flushPostingList 将内存中的倒排表写成一个新的段文件, 避免逐条写入 bbolt
 func (i *Indexer) flushPostingList() (err error) {
 	start := time.Now()
 
 	count := 0
 	for _, plMap := range i.iiMap {
-		for _, pl := range plMap {
-			i.store.AddPostingList(pl)
-			count++
-		}
+		count += len(plMap)
+	}
+
+	if err := i.store.AddSegment(i.iiMap); err != nil {
+		return err
 	}
 
 	log.Printf("%d posting list flushed in %v\n", count, time.Now().Sub(start))
 	i.iiMap = make(map[uint64]map[uint64]*PostingList)
+
+	i.maybeMerge()
 	return nil
 }
 
+// maybeMerge 在后台合并段文件, 同一时间只有一个合并任务
+func (i *Indexer) maybeMerge() {
+	if i.MergePolicy == nil || !atomic.CompareAndSwapInt32(&i.merging, 0, 1) {
+		return
+	}
+
+	policy := *i.MergePolicy
+	i.mergeWg.Add(1)
+	go func() {
+		defer i.mergeWg.Done()
+		defer atomic.StoreInt32(&i.merging, 0)
+
+		if err := i.store.MergeSegments(&policy); err != nil {
+			log.Printf("merge segments failed: %v", err)
+		}
+	}()
+}
+
+// Build 等待后台合并结束, 返回尚未写入段文件的倒排表. 倒排表交给返回值,
+// 之后新增的文档写入新的倒排表, 多次 Build().WriteTo 不会重复写入同一 posting
 func (i *Indexer) Build() *InvertIndex {
-	return &InvertIndex{
+	i.mergeWg.Wait()
+
+	ii := &InvertIndex{
 		tokenMap: i.tokenMap,
 		iiMap:    i.iiMap,
+		fields:   i.fields,
 	}
+	i.iiMap = make(map[uint64]map[uint64]*PostingList)
+	return ii
 }
 
-func (i *Indexer) addTextToPosting(docID uint64, text string) error {
+// addTermsToPosting 记录词元的 Pos 作为位置, f 为 nil 时 (没有 schema) 记录位置不记录偏移
+func (i *Indexer) addTermsToPosting(docID uint64, field string, f *FieldSpec, terms []Term) error {
 	//	start := time.Now()
 	//segs := i.seg.Segment([]byte(text))
-	terms := i.t.Tokenzie(text, false)
 
 	//SegmentTimer.UpdateSince(start)
 	IndexSegments.Update(int64(len(terms)))
 	//fmt.Printf("len(txt)=%d tokens=%d\n", len(text), len(segs))
+	positions := f == nil || f.Positions
+	offsets := f != nil && f.Offsets
+	docLen := fieldLen(terms)
+
 	for _, term := range terms {
 		start := time.Now()
-		if err := i.addTermToPosting(docID, text, &term); err != nil {
+
+		// 不记录位置时只保留词频
+		pos := 0
+		if positions {
+			pos = term.Pos
+		}
+
+		var off *Offset
+		if offsets {
+			off = &Offset{Start: term.Start, End: term.end()}
+		}
+
+		if err := i.addTermToPosting(docID, field, docLen, &term, pos, off); err != nil {
 			return err
 		}
 		AddSegTimer.UpdateSince(start)
@@ -109,8 +443,9 @@ func (i *Indexer) addTextToPosting(docID uint64, text string) error {
 	return nil
 }
 
-func (i *Indexer) addTermToPosting(docID uint64, text string, term *Term) error {
-	t, err := i.lookupToken(term.Text)
+// addTermToPosting 将词元加入 posting, docLen 为字段的词元数, off 为 nil 时不记录偏移
+func (i *Indexer) addTermToPosting(docID uint64, field string, docLen int, term *Term, pos int, off *Offset) error {
+	t, err := i.lookupToken(field, term.Text)
 	if err != nil {
 		return err
 	}
@@ -127,30 +462,65 @@ func (i *Indexer) addTermToPosting(docID uint64, text string, term *Term) error
 		pl = &PostingList{
 			TokenID: t.ID,
 			DocID:   docID,
-			DocLen:  len(text),
+			Field:   field,
+			DocLen:  docLen,
 		}
 		plMap[docID] = pl
 		t.DocCount++
+
+		if t.MinDocLen == 0 || docLen < t.MinDocLen {
+			t.MinDocLen = docLen
+		}
 	}
 	t.PosCount++
 
 	//sego.SegmentsToString
-	pl.PosList = append(pl.PosList, term.Start)
+	pl.PosList = append(pl.PosList, pos)
+	if off != nil {
+		pl.Offsets = append(pl.Offsets, *off)
+	}
+
+	if len(pl.PosList) > t.MaxFreq {
+		t.MaxFreq = len(pl.PosList)
+	}
 	return nil
 }
 
-func (i *Indexer) lookupToken(text string) (*Token, error) {
-	v, ok := i.tokenMap[text]
+func (i *Indexer) lookupToken(field, text string) (*Token, error) {
+	key := string(tokenKey(field, text))
+	v, ok := i.tokenMap[key]
 	if ok {
 		return v, nil
 	}
 
+	// 使用已有的词元, 保证重新打开索引后 tokenID 与统计信息保持一致
 	var err error
-	v, err = i.store.AllocToken(text)
+	v, err = i.store.GetToken(field, text)
 	if err != nil {
 		return nil, err
 	}
 
-	i.tokenMap[text] = v
+	i.tokenMap[key] = v
 	return v, nil
 }
+
+func (i *Indexer) fieldStats(name string) (*FieldStats, error) {
+	if i.fields == nil {
+		fields, err := i.store.GetFields()
+		if err != nil {
+			return nil, err
+		}
+
+		i.fields = make(map[string]*FieldStats)
+		for _, fs := range fields {
+			i.fields[fs.Name] = fs
+		}
+	}
+
+	fs, ok := i.fields[name]
+	if !ok {
+		fs = &FieldStats{Name: name}
+		i.fields[name] = fs
+	}
+	return fs, nil
+}
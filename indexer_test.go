@@ -0,0 +1,379 @@
+package tns
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testStore(t *testing.T) Store {
+	s, err := CreateBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// postingDocs 返回 field 中词元 value 的 posting 的文档 ID
+func postingDocs(t *testing.T, s Store, field, value string) []uint64 {
+	tk, err := s.LookupToken(field, value)
+	if err == ErrTokenNotFound {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	err = s.ScanPostingListByToken(tk.ID, func(pl *PostingList) {
+		ids = append(ids, pl.DocID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// flush 将 ix 中尚未写入的倒排表写入 s
+func flush(t *testing.T, ix *Indexer, s Store) {
+	if err := ix.Build().WriteTo(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexerBuildTwice(t *testing.T) {
+	s := testStore(t)
+	ix := NewIndexer(fieldsTokenizer{}, s)
+
+	for _, text := range []string{"a b", "a c"} {
+		if err := ix.AddDoc(&Document{Fields: map[string]string{"Text": text}}); err != nil {
+			t.Fatal(err)
+		}
+		flush(t, ix, s)
+	}
+
+	if ids := postingDocs(t, s, "Text", "a"); !reflect.DeepEqual(ids, []uint64{1, 2}) {
+		t.Fatalf("postings of a: %v", ids)
+	}
+
+	n := 0
+	s.ScanPostingList(func(pl *PostingList) { n++ })
+	if n != 4 {
+		t.Fatalf("got %d postings, want 4", n)
+	}
+}
+
+func TestIndexerDelDoc(t *testing.T) {
+	s := testStore(t)
+	ix := NewIndexer(fieldsTokenizer{}, s)
+
+	for _, text := range []string{"a b", "a c", "b c"} {
+		if err := ix.AddDoc(&Document{Fields: map[string]string{"Text": text}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush(t, ix, s)
+
+	// 已写入段文件的文档
+	if err := ix.DelDoc(1); err != nil {
+		t.Fatal(err)
+	}
+	// 仍在内存中的文档
+	if err := ix.AddDoc(&Document{Fields: map[string]string{"Text": "a d"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.DelDoc(4); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, ix, s)
+
+	if ids := postingDocs(t, s, "Text", "a"); !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Fatalf("postings of a: %v", ids)
+	}
+	if ids := postingDocs(t, s, "Text", "d"); len(ids) != 0 {
+		t.Fatalf("postings of d: %v", ids)
+	}
+
+	tk, _ := s.LookupToken("Text", "a")
+	it, err := s.PostingListIterator(tk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+		if id := it.Posting().DocID; id != 2 {
+			t.Fatalf("iterator returned deleted doc %d", id)
+		}
+	}
+	it.Close()
+
+	// idf 使用的统计信息
+	for value, want := range map[string]int{"a": 1, "b": 1, "c": 2, "d": 0} {
+		tk, err := ix.lookupToken("Text", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tk.DocCount != want || tk.PosCount != want {
+			t.Fatalf("token %s: DocCount=%d PosCount=%d, want %d", value, tk.DocCount, tk.PosCount, want)
+		}
+	}
+	fs, _ := ix.fieldStats("Text")
+	if fs.DocCount != 2 || fs.TotalLen != 4 {
+		t.Fatalf("field stats %+v", fs)
+	}
+
+	if _, err := s.GetDoc(1); err != ErrDocNotFound {
+		t.Fatalf("GetDoc(1): %v", err)
+	}
+	if err := ix.DelDoc(1); err != ErrDocNotFound {
+		t.Fatalf("DelDoc twice: %v", err)
+	}
+}
+
+func TestIndexerUpsertDoc(t *testing.T) {
+	s := testStore(t)
+	ix := NewIndexer(fieldsTokenizer{}, s)
+
+	upsert := func(key, text string) {
+		if err := ix.UpsertDoc(key, &Document{Fields: map[string]string{"Text": text}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upsert("p1", "a b")
+	upsert("p2", "a c")
+	flush(t, ix, s)
+	upsert("p1", "c d")
+	// 旧版本仍在内存中
+	upsert("p2", "e")
+	upsert("p2", "a e")
+	flush(t, ix, s)
+
+	if id, err := s.LookupDoc("p1"); err != nil || id != 3 {
+		t.Fatalf("LookupDoc(p1) = %d, %v", id, err)
+	}
+	if _, err := s.GetDoc(1); err != ErrDocNotFound {
+		t.Fatalf("old version of p1: %v", err)
+	}
+
+	for value, want := range map[string][]uint64{"a": {5}, "b": nil, "c": {3}, "d": {3}, "e": {5}} {
+		if ids := postingDocs(t, s, "Text", value); !reflect.DeepEqual(ids, want) {
+			t.Fatalf("postings of %s: %v, want %v", value, ids, want)
+		}
+
+		tk, err := ix.lookupToken("Text", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tk.DocCount != len(want) || tk.PosCount != len(want) {
+			t.Fatalf("token %s: DocCount=%d PosCount=%d, want %d", value, tk.DocCount, tk.PosCount, len(want))
+		}
+	}
+
+	fs, _ := ix.fieldStats("Text")
+	if fs.DocCount != 2 || fs.TotalLen != 4 {
+		t.Fatalf("field stats %+v", fs)
+	}
+}
+
+func TestIndexerDelUpsertedDoc(t *testing.T) {
+	s := testStore(t)
+	ix := NewIndexer(fieldsTokenizer{}, s)
+
+	doc := &Document{Fields: map[string]string{"Text": "a b"}}
+	if err := ix.UpsertDoc("p1", doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.DelDoc(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.LookupDoc("p1"); err != ErrDocNotFound {
+		t.Fatalf("LookupDoc after DelDoc = %d, %v", id, err)
+	}
+
+	// key 可以重新使用
+	if err := ix.UpsertDoc("p1", &Document{Fields: map[string]string{"Text": "a c"}}); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, ix, s)
+
+	if id, err := s.LookupDoc("p1"); err != nil || id != 2 {
+		t.Fatalf("LookupDoc(p1) = %d, %v", id, err)
+	}
+	for value, want := range map[string][]uint64{"a": {2}, "b": nil, "c": {2}} {
+		if ids := postingDocs(t, s, "Text", value); !reflect.DeepEqual(ids, want) {
+			t.Fatalf("postings of %s: %v, want %v", value, ids, want)
+		}
+	}
+	fs, _ := ix.fieldStats("Text")
+	if fs.DocCount != 1 || fs.TotalLen != 2 {
+		t.Fatalf("field stats %+v", fs)
+	}
+}
+
+func TestIndexerDelUnstoredField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	// reopen 关闭并重新打开索引 u, 写入尚未保存的词元与字段统计
+	var root Store
+	reopen := func() Store {
+		if root != nil {
+			root.Close()
+		}
+		var err error
+		if root, err = CreateBoltStore(path); err != nil {
+			t.Fatal(err)
+		}
+		s, err := root.Index("u")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	t.Cleanup(func() { root.Close() })
+
+	var err error
+	if root, err = CreateBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	spec := &IndexSpec{Name: "u", Fields: []*FieldSpec{
+		{Name: "Title", Type: FieldText, Indexed: true, Stored: true},
+		{Name: "Text", Type: FieldText, Indexed: true},
+	}}
+	if err := root.CreateIndex(spec); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := root.Index("u")
+
+	ix := NewIndexer(fieldsTokenizer{}, s)
+	for _, text := range []string{"a a b", "a c"} {
+		if err := ix.AddDoc(&Document{Fields: map[string]string{"Title": "t", "Text": text}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush(t, ix, s)
+
+	// 重新打开后只能从存储层得到未保存字段的词频
+	s = reopen()
+	ix = NewIndexer(fieldsTokenizer{}, s)
+	if err := ix.DelDoc(1); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, ix, s)
+	s = reopen()
+
+	for value, want := range map[string]int{"a": 1, "b": 0, "c": 1} {
+		tk, err := s.LookupToken("Text", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tk.DocCount != want || tk.PosCount != want {
+			t.Fatalf("token %s: DocCount=%d PosCount=%d, want %d", value, tk.DocCount, tk.PosCount, want)
+		}
+	}
+
+	fields, err := s.GetFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range fields {
+		if fs.DocCount != 1 || (fs.Name == "Text" && fs.TotalLen != 2) || (fs.Name == "Title" && fs.TotalLen != 1) {
+			t.Fatalf("field stats %+v", fs)
+		}
+	}
+
+	if fts, err := s.docTerms(1); err != nil || fts != nil {
+		t.Fatalf("terms of deleted doc: %v, %v", fts, err)
+	}
+}
+
+func TestIndexerFieldLenPositions(t *testing.T) {
+	root := testStore(t)
+	spec := &IndexSpec{Name: "p", Fields: []*FieldSpec{
+		{Name: "Title", Type: FieldText, Indexed: true, Stored: true, Positions: true, Analyzer: "multi"},
+		{Name: "Text", Type: FieldText, Indexed: true, Stored: true, Positions: true, Analyzer: "syn"},
+	}}
+	if err := root.CreateIndex(spec); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := root.Index("p")
+
+	ix := NewIndexer(fieldsTokenizer{}, s)
+	ix.AddAnalyzer("multi", MultiTokenizer{NewNGramTokenizer(2), fieldsTokenizer{}})
+	ix.AddAnalyzer("syn", &Analyzer{Tokenizer: fieldsTokenizer{}, TokenFilters: []TokenFilter{NewSynonymFilter([]string{"quick", "fast"})}})
+
+	// 同一位置的多个词元只计一次: Title 5 个词元 4 个位置, Text 4 个词元 3 个位置
+	for _, d := range []map[string]string{
+		{"Title": "北京大学 go", "Text": "the quick fox"},
+		{"Title": "x", "Text": "a b"},
+	} {
+		if err := ix.AddDoc(&Document{Fields: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush(t, ix, s)
+
+	checkLen := func(want map[string]int64) {
+		t.Helper()
+		for name, l := range want {
+			if fs, _ := ix.fieldStats(name); fs.TotalLen != l {
+				t.Fatalf("field stats %+v, want TotalLen %d", fs, l)
+			}
+		}
+	}
+	checkLen(map[string]int64{"Title": 5, "Text": 5})
+
+	for _, c := range []struct {
+		field, value string
+		docLen       int
+	}{{"Title", "北京大学", 4}, {"Title", "京大", 4}, {"Text", "fast", 3}, {"Text", "fox", 3}} {
+		tk, err := s.LookupToken(c.field, c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.ScanPostingListByToken(tk.ID, func(pl *PostingList) {
+			if pl.DocLen != c.docLen {
+				t.Fatalf("%s:%s DocLen = %d, want %d", c.field, c.value, pl.DocLen, c.docLen)
+			}
+		})
+	}
+
+	if err := ix.DelDoc(1); err != nil {
+		t.Fatal(err)
+	}
+	checkLen(map[string]int64{"Title": 1, "Text": 2})
+}
+
+// segmentFailStore 写入段文件总是失败, 记录被更新的统计信息
+type segmentFailStore struct {
+	Store
+	updated int
+}
+
+func (s *segmentFailStore) AddSegment(map[uint64]map[uint64]*PostingList) error {
+	return errors.New("disk full")
+}
+
+func (s *segmentFailStore) UpdateToken(*Token) error {
+	s.updated++
+	return nil
+}
+
+func (s *segmentFailStore) UpdateField(*FieldStats) error {
+	s.updated++
+	return nil
+}
+
+func TestInvertIndexWriteToError(t *testing.T) {
+	s := &segmentFailStore{Store: testStore(t)}
+	ix := NewIndexer(fieldsTokenizer{}, s)
+	if err := ix.AddDoc(&Document{Fields: map[string]string{"Text": "a b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ix.Build().WriteTo(s); err == nil {
+		t.Fatal("expect error")
+	}
+	if s.updated != 0 {
+		t.Fatalf("%d stats updated after failed flush", s.updated)
+	}
+}
@@ -1,10 +1,635 @@
 package tns
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
 	"os"
+	"sort"
 )
 
+// InvertFile 是一个不可变的倒排段 (segment) 文件, 一旦写入不再修改.
+//
+// 文件布局:
+//
+//	header    magic(4) | version(1)
+//	positions 每个 posting 的位置列表以及字节偏移, 格式同 appendPositions / appendOffsets
+//	postings  每个词元的 posting: [跳表] | uvarint(docID delta) | uvarint(DocLen)...
+//	          posting 每 128 个为一个 block, 超过一个 block 的词元在 posting 之前写入每个 block 的跳表项:
+//	          last docID(8) | block postings 结束偏移(8) | block positions 结束偏移(8), 偏移相对于词元的起点
+//	dict      uvarint(字段数) | 每个字段: uvarint(len) | name |
+//	          uvarint(词元数) | 每个词元: uvarint(tokenID delta) | uvarint(字段序号) | uvarint(docFreq) |
+//	          uvarint(postings offset) | uvarint(positions offset)
+//	footer    positions offset(8) | postings offset(8) | dict offset(8) | crc32(4) | magic(4)
+//
+// crc32 覆盖 footer 之前的全部内容. 词元按 tokenID 升序, 同一词元的 posting 按 docID 升序.
+// version 1 ~ 4 是开发中的格式, 不再支持.
 type InvertFile struct {
 	path string
 	fp   *os.File
+
+	size         int64
+	positionsOff int64
+	postingsOff  int64
+	dictOff      int64
+
+	terms []invertFileTerm
+}
+
+type invertFileTerm struct {
+	tokenID      uint64
+	field        string
+	docFreq      int
+	postingsOff  int64
+	positionsOff int64
+}
+
+const (
+	invertFileVersion    byte = 5
+	invertFileHeaderSize      = 5
+	invertFileFooterSize      = 32
+
+	invertFileBlockSize = 128
+	invertFileSkipSize  = 24
+)
+
+// invertFileSkip 一个 block 的跳表项, 结束偏移相对于词元 posting 数据 / positions 的起点
+type invertFileSkip struct {
+	lastDocID    uint64
+	postingsEnd  int64
+	positionsEnd int64
+}
+
+var (
+	invertFileMagic = []byte("TNSI")
+
+	ErrBadInvertFile = errors.New("bad invert file")
+)
+
+// WriteInvertFile 将内存中的倒排表 (tokenID -> docID -> PostingList) 写成一个段文件.
+// 先写入临时文件, 完成后再 rename, 保证 path 上的文件总是完整的.
+func WriteInvertFile(path string, iiMap map[uint64]map[uint64]*PostingList) error {
+	w, err := newInvertFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer w.abort()
+
+	tokenIDs := make([]uint64, 0, len(iiMap))
+	for tokenID, plMap := range iiMap {
+		if len(plMap) > 0 {
+			tokenIDs = append(tokenIDs, tokenID)
+		}
+	}
+	sort.Slice(tokenIDs, func(i, j int) bool { return tokenIDs[i] < tokenIDs[j] })
+
+	var pls []*PostingList
+	for _, tokenID := range tokenIDs {
+		pls = pls[:0]
+		for _, pl := range iiMap[tokenID] {
+			pls = append(pls, pl)
+		}
+		sort.Slice(pls, func(i, j int) bool { return pls[i].DocID < pls[j].DocID })
+
+		if err := w.addTerm(tokenID, pls); err != nil {
+			return err
+		}
+	}
+
+	return w.commit()
+}
+
+// invertFileWriter 按 tokenID 升序逐个写入词元, 合并段时无需把整个段载入内存
+type invertFileWriter struct {
+	path string
+	fp   *os.File
+	crc  hash.Hash32
+	w    *bufio.Writer
+
+	off int64
+
+	// positions 直接写入文件, postings 和 dict 较小, 先缓存在内存中
+	postings []byte
+	data     []byte
+	buf      []byte
+	terms    []invertFileTerm
+	fields   map[string]int
+}
+
+func newInvertFileWriter(path string) (*invertFileWriter, error) {
+	fp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	crc := crc32.NewIEEE()
+	w := &invertFileWriter{
+		path: path,
+		fp:   fp,
+		crc:  crc,
+		w:    bufio.NewWriterSize(io.MultiWriter(fp, crc), 1<<20),
+		off:  invertFileHeaderSize,
+
+		fields: make(map[string]int),
+	}
+
+	w.w.Write(invertFileMagic)
+	w.w.WriteByte(invertFileVersion)
+	return w, nil
+}
+
+// addTerm 写入一个词元的全部 posting, 要求 tokenID 递增且 pls 按 docID 升序
+func (w *invertFileWriter) addTerm(tokenID uint64, pls []*PostingList) error {
+	if len(pls) == 0 {
+		return nil
+	}
+
+	field := pls[0].Field
+	if _, ok := w.fields[field]; !ok {
+		w.fields[field] = len(w.fields)
+	}
+
+	positionsOff := w.off
+	w.terms = append(w.terms, invertFileTerm{
+		tokenID:      tokenID,
+		field:        field,
+		docFreq:      len(pls),
+		postingsOff:  int64(len(w.postings)),
+		positionsOff: positionsOff,
+	})
+
+	var skips []invertFileSkip
+	var prev uint64
+	w.data = w.data[:0]
+	for j, pl := range pls {
+		w.data = binary.AppendUvarint(w.data, pl.DocID-prev)
+		w.data = binary.AppendUvarint(w.data, uint64(pl.DocLen))
+		prev = pl.DocID
+
+		w.buf = appendPositions(w.buf[:0], pl.PosList)
+		w.buf = appendOffsets(w.buf, pl.Offsets)
+		if _, err := w.w.Write(w.buf); err != nil {
+			return err
+		}
+		w.off += int64(len(w.buf))
+
+		if (j+1)%invertFileBlockSize == 0 || j == len(pls)-1 {
+			skips = append(skips, invertFileSkip{
+				lastDocID:    pl.DocID,
+				postingsEnd:  int64(len(w.data)),
+				positionsEnd: w.off - positionsOff,
+			})
+		}
+	}
+
+	if len(skips) > 1 {
+		for _, skip := range skips {
+			w.postings = binary.BigEndian.AppendUint64(w.postings, skip.lastDocID)
+			w.postings = binary.BigEndian.AppendUint64(w.postings, uint64(skip.postingsEnd))
+			w.postings = binary.BigEndian.AppendUint64(w.postings, uint64(skip.positionsEnd))
+		}
+	}
+	w.postings = append(w.postings, w.data...)
+
+	return nil
+}
+
+func (w *invertFileWriter) commit() error {
+	positionsOff := int64(invertFileHeaderSize)
+	postingsOff := w.off
+	dictOff := postingsOff + int64(len(w.postings))
+
+	fields := make([]string, len(w.fields))
+	for name, i := range w.fields {
+		fields[i] = name
+	}
+
+	dict := binary.AppendUvarint(nil, uint64(len(fields)))
+	for _, name := range fields {
+		dict = binary.AppendUvarint(dict, uint64(len(name)))
+		dict = append(dict, name...)
+	}
+
+	dict = binary.AppendUvarint(dict, uint64(len(w.terms)))
+	var prev uint64
+	for _, t := range w.terms {
+		dict = binary.AppendUvarint(dict, t.tokenID-prev)
+		dict = binary.AppendUvarint(dict, uint64(w.fields[t.field]))
+		dict = binary.AppendUvarint(dict, uint64(t.docFreq))
+		dict = binary.AppendUvarint(dict, uint64(t.postingsOff))
+		dict = binary.AppendUvarint(dict, uint64(t.positionsOff))
+		prev = t.tokenID
+	}
+
+	w.w.Write(w.postings)
+	w.w.Write(dict)
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+
+	footer := make([]byte, 0, invertFileFooterSize)
+	footer = binary.BigEndian.AppendUint64(footer, uint64(positionsOff))
+	footer = binary.BigEndian.AppendUint64(footer, uint64(postingsOff))
+	footer = binary.BigEndian.AppendUint64(footer, uint64(dictOff))
+	footer = binary.BigEndian.AppendUint32(footer, w.crc.Sum32())
+	footer = append(footer, invertFileMagic...)
+
+	if _, err := w.fp.Write(footer); err != nil {
+		return err
+	}
+	if err := w.fp.Sync(); err != nil {
+		return err
+	}
+	if err := w.fp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(w.fp.Name(), w.path)
+}
+
+// abort 清理未提交的临时文件, commit 成功后调用无副作用
+func (w *invertFileWriter) abort() {
+	w.fp.Close()
+	os.Remove(w.fp.Name())
+}
+
+// OpenInvertFile 打开段文件, 校验 crc 并加载词典
+func OpenInvertFile(path string) (*InvertFile, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &InvertFile{path: path, fp: fp}
+	if err := f.load(); err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *InvertFile) load() error {
+	st, err := f.fp.Stat()
+	if err != nil {
+		return err
+	}
+	f.size = st.Size()
+
+	if f.size < invertFileHeaderSize+invertFileFooterSize {
+		return ErrBadInvertFile
+	}
+
+	footer := make([]byte, invertFileFooterSize)
+	if _, err := f.fp.ReadAt(footer, f.size-invertFileFooterSize); err != nil {
+		return err
+	}
+	if !bytes.Equal(footer[28:], invertFileMagic) {
+		return ErrBadInvertFile
+	}
+
+	f.positionsOff = int64(binary.BigEndian.Uint64(footer[0:]))
+	f.postingsOff = int64(binary.BigEndian.Uint64(footer[8:]))
+	f.dictOff = int64(binary.BigEndian.Uint64(footer[16:]))
+	sum := binary.BigEndian.Uint32(footer[24:])
+
+	end := f.size - invertFileFooterSize
+	if f.positionsOff != invertFileHeaderSize || f.postingsOff < f.positionsOff ||
+		f.dictOff < f.postingsOff || f.dictOff > end {
+		return ErrBadInvertFile
+	}
+
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, io.NewSectionReader(f.fp, 0, end)); err != nil {
+		return err
+	}
+	if crc.Sum32() != sum {
+		return ErrBadInvertFile
+	}
+
+	header := make([]byte, invertFileHeaderSize)
+	if _, err := f.fp.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:4], invertFileMagic) || header[4] != invertFileVersion {
+		return ErrBadInvertFile
+	}
+
+	dict := make([]byte, end-f.dictOff)
+	if _, err := f.fp.ReadAt(dict, f.dictOff); err != nil {
+		return err
+	}
+
+	r := bytes.NewReader(dict)
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(len(dict)) {
+		return ErrBadInvertFile
+	}
+
+	fields := make([]string, n)
+	for i := range fields {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return ErrBadInvertFile
+		}
+
+		name := make([]byte, l)
+		r.Read(name)
+		fields[i] = string(name)
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(len(dict)) {
+		return ErrBadInvertFile
+	}
+
+	f.terms = make([]invertFileTerm, count)
+	var prev uint64
+	for i := range f.terms {
+		// tokenID delta, 字段序号, docFreq, postings offset, positions offset
+		var v [5]uint64
+		for j := range v {
+			if v[j], err = binary.ReadUvarint(r); err != nil {
+				return ErrBadInvertFile
+			}
+		}
+
+		if v[1] >= uint64(len(fields)) {
+			return ErrBadInvertFile
+		}
+
+		prev += v[0]
+		f.terms[i] = invertFileTerm{
+			tokenID:      prev,
+			field:        fields[v[1]],
+			docFreq:      int(v[2]),
+			postingsOff:  int64(v[3]),
+			positionsOff: int64(v[4]),
+		}
+	}
+
+	return nil
+}
+
+func (f *InvertFile) Path() string {
+	return f.path
+}
+
+// Size 返回段文件大小 (字节)
+func (f *InvertFile) Size() int64 {
+	return f.size
+}
+
+// ScanPostingListByToken 按 docID 升序遍历 tokenID 的全部 posting
+func (f *InvertFile) ScanPostingListByToken(tokenID uint64, fn func(pl *PostingList)) error {
+	i := sort.Search(len(f.terms), func(i int) bool { return f.terms[i].tokenID >= tokenID })
+	if i == len(f.terms) || f.terms[i].tokenID != tokenID {
+		return nil
+	}
+
+	return f.scanTerm(i, fn)
+}
+
+// ScanPostingList 按 (tokenID, docID) 升序遍历段内全部 posting
+func (f *InvertFile) ScanPostingList(fn func(pl *PostingList)) error {
+	for i := range f.terms {
+		if err := f.scanTerm(i, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *InvertFile) scanTerm(i int, fn func(pl *PostingList)) error {
+	it, err := f.termIterator(i)
+	if err != nil {
+		return err
+	}
+
+	for it.Next() {
+		fn(it.Posting())
+	}
+	return it.Err()
+}
+
+// Iterator 返回 tokenID 的 posting 迭代器, 段中没有该词元时返回 nil
+func (f *InvertFile) Iterator(tokenID uint64) (PostingIterator, error) {
+	i := sort.Search(len(f.terms), func(i int) bool { return f.terms[i].tokenID >= tokenID })
+	if i == len(f.terms) || f.terms[i].tokenID != tokenID {
+		return nil, nil
+	}
+
+	return f.termIterator(i)
+}
+
+func (f *InvertFile) termIterator(i int) (*invertFileIterator, error) {
+	t := f.terms[i]
+
+	postingsEnd, positionsEnd := f.dictOff-f.postingsOff, f.postingsOff
+	if i+1 < len(f.terms) {
+		postingsEnd, positionsEnd = f.terms[i+1].postingsOff, f.terms[i+1].positionsOff
+	}
+
+	it := &invertFileIterator{
+		f:       f,
+		t:       t,
+		dataOff: f.postingsOff + t.postingsOff,
+		block:   -1,
+	}
+
+	blocks := (t.docFreq + invertFileBlockSize - 1) / invertFileBlockSize
+	if blocks <= 1 {
+		// 只有一个 block 时没有跳表
+		it.skips = []invertFileSkip{{
+			lastDocID:    math.MaxUint64,
+			postingsEnd:  postingsEnd - t.postingsOff,
+			positionsEnd: positionsEnd - t.positionsOff,
+		}}
+		return it, nil
+	}
+
+	buf := make([]byte, blocks*invertFileSkipSize)
+	if _, err := f.fp.ReadAt(buf, it.dataOff); err != nil {
+		return nil, err
+	}
+	it.dataOff += int64(len(buf))
+
+	it.skips = make([]invertFileSkip, blocks)
+	for j := range it.skips {
+		e := buf[j*invertFileSkipSize:]
+		it.skips[j] = invertFileSkip{
+			lastDocID:    binary.BigEndian.Uint64(e),
+			postingsEnd:  int64(binary.BigEndian.Uint64(e[8:])),
+			positionsEnd: int64(binary.BigEndian.Uint64(e[16:])),
+		}
+	}
+
+	last := it.skips[blocks-1]
+	if f.postingsOff+t.postingsOff+int64(len(buf))+last.postingsEnd != f.postingsOff+postingsEnd ||
+		t.positionsOff+last.positionsEnd != positionsEnd {
+		return nil, ErrBadInvertFile
+	}
+	return it, nil
+}
+
+// invertFileIterator 按 block 读取一个词元的 posting, Advance 借助跳表直接定位到目标 block
+type invertFileIterator struct {
+	f       *InvertFile
+	t       invertFileTerm
+	skips   []invertFileSkip
+	dataOff int64 // posting 数据 (跳表之后) 在文件中的偏移
+
+	block     int
+	remaining int // 当前 block 中未读取的 posting 数
+	postings  []byte
+	positions []byte
+	docID     uint64
+
+	pl  *PostingList
+	err error
+}
+
+func (it *invertFileIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for it.remaining == 0 {
+		if it.block+1 >= len(it.skips) {
+			it.pl = nil
+			return false
+		}
+		if it.err = it.loadBlock(it.block + 1); it.err != nil {
+			return false
+		}
+	}
+
+	if it.err = it.decode(); it.err != nil {
+		return false
+	}
+	return true
+}
+
+func (it *invertFileIterator) Advance(target uint64) bool {
+	if it.pl != nil && it.pl.DocID >= target {
+		return true
+	}
+	if it.err != nil || (it.pl == nil && it.block >= 0) {
+		return false
+	}
+
+	// 跳过 last docID 小于 target 的 block
+	start := it.block
+	if start < 0 {
+		start = 0
+	}
+	rest := it.skips[start:]
+	b := start + sort.Search(len(rest), func(j int) bool { return rest[j].lastDocID >= target })
+	if b == len(it.skips) {
+		it.block, it.remaining, it.pl = len(it.skips), 0, nil
+		return false
+	}
+	if b != it.block {
+		if it.err = it.loadBlock(b); it.err != nil {
+			return false
+		}
+	}
+
+	for it.Next() {
+		if it.pl.DocID >= target {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *invertFileIterator) Posting() *PostingList {
+	return it.pl
+}
+
+func (it *invertFileIterator) Err() error {
+	return it.err
+}
+
+func (it *invertFileIterator) Close() error {
+	return nil
+}
+
+func (it *invertFileIterator) loadBlock(b int) error {
+	var postingsStart, positionsStart int64
+	it.docID = 0
+	if b > 0 {
+		prev := it.skips[b-1]
+		postingsStart, positionsStart, it.docID = prev.postingsEnd, prev.positionsEnd, prev.lastDocID
+	}
+
+	skip := it.skips[b]
+	if skip.postingsEnd < postingsStart || skip.positionsEnd < positionsStart {
+		return ErrBadInvertFile
+	}
+
+	it.postings = make([]byte, skip.postingsEnd-postingsStart)
+	if _, err := it.f.fp.ReadAt(it.postings, it.dataOff+postingsStart); err != nil {
+		return err
+	}
+
+	it.positions = make([]byte, skip.positionsEnd-positionsStart)
+	if _, err := it.f.fp.ReadAt(it.positions, it.t.positionsOff+positionsStart); err != nil {
+		return err
+	}
+
+	it.block = b
+	it.remaining = it.t.docFreq - b*invertFileBlockSize
+	if len(it.skips) > 1 && it.remaining > invertFileBlockSize {
+		it.remaining = invertFileBlockSize
+	}
+	return nil
+}
+
+func (it *invertFileIterator) decode() error {
+	delta, n := binary.Uvarint(it.postings)
+	if n <= 0 {
+		return ErrBadInvertFile
+	}
+	it.postings = it.postings[n:]
+
+	docLen, n := binary.Uvarint(it.postings)
+	if n <= 0 {
+		return ErrBadInvertFile
+	}
+	it.postings = it.postings[n:]
+
+	pos, n, err := readPositions(it.positions)
+	if err != nil {
+		return err
+	}
+	it.positions = it.positions[n:]
+
+	offsets, n, err := readOffsets(it.positions)
+	if err != nil {
+		return err
+	}
+	it.positions = it.positions[n:]
+
+	it.docID += delta
+	it.remaining--
+	it.pl = &PostingList{
+		TokenID: it.t.tokenID,
+		DocID:   it.docID,
+		Field:   it.t.field,
+		DocLen:  int(docLen),
+		PosList: pos,
+		Offsets: offsets,
+	}
+	return nil
+}
+
+func (f *InvertFile) Close() error {
+	return f.fp.Close()
 }
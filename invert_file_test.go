@@ -0,0 +1,126 @@
+package tns_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/zhaoyao/tns"
+)
+
+func TestInvertFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.seg")
+
+	iiMap := map[uint64]map[uint64]*tns.PostingList{
+		7: {
+			3: {TokenID: 7, DocID: 3, Field: "Text", DocLen: 10, PosList: []int{1, 4}, Offsets: []tns.Offset{{3, 6}, {12, 15}}},
+			1: {TokenID: 7, DocID: 1, Field: "Text", DocLen: 20, PosList: []int{0}},
+		},
+		2: {
+			9: {TokenID: 2, DocID: 9, Field: "Title", DocLen: 5, PosList: []int{2, 3, 100}},
+		},
+	}
+
+	if err := tns.WriteInvertFile(path, iiMap); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := tns.OpenInvertFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []*tns.PostingList
+	f.ScanPostingListByToken(7, func(pl *tns.PostingList) { got = append(got, pl) })
+
+	want := []*tns.PostingList{iiMap[7][1], iiMap[7][3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	n := 0
+	f.ScanPostingList(func(pl *tns.PostingList) { n++ })
+	if n != 3 {
+		t.Fatalf("scanned %d posting lists, want 3", n)
+	}
+
+	got = nil
+	f.ScanPostingListByToken(5, func(pl *tns.PostingList) { got = append(got, pl) })
+	if len(got) != 0 {
+		t.Fatalf("unexpected posting lists %+v", got)
+	}
+}
+
+func TestInvertFileChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.seg")
+
+	iiMap := map[uint64]map[uint64]*tns.PostingList{
+		1: {1: {TokenID: 1, DocID: 1, DocLen: 3, PosList: []int{1}}},
+	}
+	if err := tns.WriteInvertFile(path, iiMap); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(path)
+	b[6] ^= 0xff
+	os.WriteFile(path, b, 0644)
+
+	if _, err := tns.OpenInvertFile(path); err != tns.ErrBadInvertFile {
+		t.Fatalf("expect ErrBadInvertFile, got %v", err)
+	}
+}
+
+func TestInvertFileIterator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.seg")
+
+	// 1000 个 posting 分为多个 block, docID 为 3 的倍数
+	plMap := make(map[uint64]*tns.PostingList)
+	for i := uint64(1); i <= 1000; i++ {
+		plMap[i*3] = &tns.PostingList{TokenID: 5, DocID: i * 3, DocLen: int(i), PosList: []int{int(i)}}
+	}
+	iiMap := map[uint64]map[uint64]*tns.PostingList{
+		5: plMap,
+		6: {1: {TokenID: 6, DocID: 1, DocLen: 1, PosList: []int{0}}},
+	}
+	if err := tns.WriteInvertFile(path, iiMap); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := tns.OpenInvertFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	n := 0
+	f.ScanPostingListByToken(5, func(pl *tns.PostingList) {
+		n++
+		if pl.DocID != uint64(n*3) || pl.PosList[0] != n {
+			t.Fatalf("unexpected posting %+v", pl)
+		}
+	})
+	if n != 1000 {
+		t.Fatalf("scanned %d posting lists, want 1000", n)
+	}
+
+	it, err := f.Iterator(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ target, want uint64 }{
+		{0, 3}, {3, 3}, {4, 6}, {386, 387}, {387, 387}, {2000, 2001}, {2999, 3000},
+	} {
+		if !it.Advance(c.target) || it.Posting().DocID != c.want {
+			t.Fatalf("advance to %d: got %+v, want %d", c.target, it.Posting(), c.want)
+		}
+	}
+	if it.Advance(3001) || it.Next() || it.Err() != nil {
+		t.Fatalf("expect exhausted iterator, err %v", it.Err())
+	}
+
+	if it, _ := f.Iterator(7); it != nil {
+		t.Fatal("unexpected iterator for missing token")
+	}
+}
@@ -3,6 +3,7 @@ package tns
 import (
 	"fmt"
 	"log"
+	"sort"
 	"time"
 )
 
@@ -15,32 +16,56 @@ type InvertIndex struct {
 	// invert index map tokenID -> (docID, postingList)
 	iiMap map[uint64]map[uint64]*PostingList
 
+	// fields 字段名 -> 字段统计信息
+	fields map[string]*FieldStats
+
 	store Store
 }
 
-func (ii *InvertIndex) WriteTo(store Store) {
+// WriteTo 将倒排表写成一个段文件, 再更新词元与字段的统计信息. 段文件写入失败时不更新统计信息
+func (ii *InvertIndex) WriteTo(store Store) error {
 	log.Println("start flush index")
 	start := time.Now()
 	i := 0
 	for _, plMap := range ii.iiMap {
-		for _, pl := range plMap {
-			store.AddPostingList(pl)
-			i++
-		}
+		i += len(plMap)
+	}
+
+	if err := store.AddSegment(ii.iiMap); err != nil {
+		return fmt.Errorf("flush segment: %w", err)
 	}
 
 	for _, tk := range ii.tokenMap {
 		fmt.Printf("%s --> %v\n", tk.Value, tk.DocCount)
-		store.UpdateToken(tk)
+		if err := store.UpdateToken(tk); err != nil {
+			return err
+		}
+	}
+
+	for _, fs := range ii.fields {
+		if err := store.UpdateField(fs); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("tokens: %d", len(ii.tokenMap))
 	log.Printf("pl: %d", i)
 	log.Printf("index flushed in %v\n", time.Now().Sub(start))
+	return nil
 }
 
-func (ii *InvertIndex) FetchPostingList(v string) (*Token, error) {
-	return ii.store.GetToken(v)
+func (ii *InvertIndex) FetchPostingList(field, v string) (*Token, error) {
+	return ii.store.GetToken(field, v)
+}
+
+// Fields 返回索引中出现过的字段名
+func (ii *InvertIndex) Fields() []string {
+	names := make([]string, 0, len(ii.fields))
+	for name := range ii.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func LoadInvertIndex(store Store) (*InvertIndex, error) {
@@ -48,6 +73,8 @@ func LoadInvertIndex(store Store) (*InvertIndex, error) {
 	ii := &InvertIndex{
 		tokenMap: make(map[string]*Token),
 		iiMap:    make(map[uint64]map[uint64]*PostingList),
+		fields:   make(map[string]*FieldStats),
+		store:    store,
 	}
 
 	var err error
@@ -56,6 +83,14 @@ func LoadInvertIndex(store Store) (*InvertIndex, error) {
 		return nil, err
 	}
 
+	fields, err := store.GetFields()
+	if err != nil {
+		return nil, err
+	}
+	for _, fs := range fields {
+		ii.fields[fs.Name] = fs
+	}
+
 	// store.ScanToken(func(token *Token) {
 	// 	//log.Printf("%s --> %v\n", token.Value, token.DocCount)
 	// 	ii.tokenMap[token.Value] = &(*token)
@@ -82,3 +117,12 @@ func LoadInvertIndex(store Store) (*InvertIndex, error) {
 
 	return ii, nil
 }
+
+// stats 索引中已有的统计信息, 旧版本的索引没有字段统计信息
+func (ii *InvertIndex) stats() Stat {
+	st := StatDocFreq | StatCollectionFreq
+	if len(ii.fields) > 0 {
+		st |= StatFieldLength
+	}
+	return st
+}
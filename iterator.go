@@ -0,0 +1,154 @@
+package tns
+
+import "sort"
+
+// PostingIterator 按 docID 升序遍历一个词元的 posting, 用完需要 Close
+type PostingIterator interface {
+	// Next 移动到下一个 posting, 没有更多 posting 或出错时返回 false
+	Next() bool
+	// Advance 移动到第一个 DocID >= target 的 posting, 当前 posting 已满足时不移动
+	Advance(target uint64) bool
+	// Posting 当前 posting, 每次移动都会返回新的 PostingList
+	Posting() *PostingList
+	Err() error
+	Close() error
+}
+
+// sliceIterator 遍历内存中按 docID 排序的 posting
+type sliceIterator struct {
+	pls []*PostingList
+	i   int
+}
+
+func newSliceIterator(pls []*PostingList) *sliceIterator {
+	return &sliceIterator{pls: pls, i: -1}
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.i < len(it.pls) {
+		it.i++
+	}
+	return it.i < len(it.pls)
+}
+
+func (it *sliceIterator) Advance(target uint64) bool {
+	start := it.i
+	if start < 0 {
+		start = 0
+	}
+	rest := it.pls[start:]
+	it.i = start + sort.Search(len(rest), func(j int) bool { return rest[j].DocID >= target })
+	return it.i < len(it.pls)
+}
+
+func (it *sliceIterator) Posting() *PostingList {
+	if it.i < 0 || it.i >= len(it.pls) {
+		return nil
+	}
+	return it.pls[it.i]
+}
+
+func (it *sliceIterator) Err() error   { return nil }
+func (it *sliceIterator) Close() error { return nil }
+
+// mergedIterator 按 docID 归并多个迭代器 (同一个文档只会出现在一个迭代器中), 跳过 skip 返回 true 的文档
+type mergedIterator struct {
+	subs    []PostingIterator
+	ok      []bool
+	cur     int
+	started bool
+
+	skip    func(docID uint64) bool
+	onClose func()
+	closed  bool
+}
+
+func newMergedIterator(subs []PostingIterator, skip func(docID uint64) bool, onClose func()) *mergedIterator {
+	return &mergedIterator{
+		subs:    subs,
+		ok:      make([]bool, len(subs)),
+		cur:     -1,
+		skip:    skip,
+		onClose: onClose,
+	}
+}
+
+func (m *mergedIterator) Next() bool {
+	if !m.started {
+		m.started = true
+		for i, sub := range m.subs {
+			m.ok[i] = sub.Next()
+		}
+	} else if m.cur >= 0 {
+		m.ok[m.cur] = m.subs[m.cur].Next()
+	}
+	return m.pick()
+}
+
+func (m *mergedIterator) Advance(target uint64) bool {
+	if pl := m.Posting(); pl != nil && pl.DocID >= target {
+		return true
+	}
+
+	for i, sub := range m.subs {
+		if !m.started || (m.ok[i] && sub.Posting().DocID < target) {
+			m.ok[i] = sub.Advance(target)
+		}
+	}
+	m.started = true
+	return m.pick()
+}
+
+// pick 选出当前 docID 最小的子迭代器
+func (m *mergedIterator) pick() bool {
+	for {
+		m.cur = -1
+		for i, sub := range m.subs {
+			if m.ok[i] && (m.cur < 0 || sub.Posting().DocID < m.subs[m.cur].Posting().DocID) {
+				m.cur = i
+			}
+		}
+		if m.cur < 0 {
+			return false
+		}
+
+		if m.skip == nil || !m.skip(m.subs[m.cur].Posting().DocID) {
+			return true
+		}
+		m.ok[m.cur] = m.subs[m.cur].Next()
+	}
+}
+
+func (m *mergedIterator) Posting() *PostingList {
+	if m.cur < 0 {
+		return nil
+	}
+	return m.subs[m.cur].Posting()
+}
+
+func (m *mergedIterator) Err() error {
+	for _, sub := range m.subs {
+		if err := sub.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mergedIterator) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	var err error
+	for _, sub := range m.subs {
+		if e := sub.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if m.onClose != nil {
+		m.onClose()
+	}
+	return err
+}
@@ -0,0 +1,625 @@
+package tns
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// noMoreDocs docMatcher 没有更多文档时的 docID
+const noMoreDocs = math.MaxUint64
+
+// docMatcher 按 docID 升序逐个文档 (document-at-a-time) 执行查询.
+// 创建后即位于第一个命中的文档上.
+type docMatcher interface {
+	docID() uint64
+	// advance 移动到第一个 docID >= target 的文档, 当前文档已满足时不移动
+	advance(target uint64)
+	// collect 追加当前文档命中的词元
+	collect(hits []*termHit) []*termHit
+	// close 关闭底层的 posting 迭代器, 返回遍历过程中的错误
+	close() error
+}
+
+func closeMatchers(ms []docMatcher) error {
+	var err error
+	for _, m := range ms {
+		if e := m.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// matcher 将查询转换为 docMatcher
+func (s *Searcher) matcher(q Query, allFields []string) (docMatcher, error) {
+	switch q := q.(type) {
+	case *TermQuery:
+		return s.termMatcher(q, allFields)
+	case *PhraseQuery:
+		hs, err := s.matchPhrase(q, allFields)
+		if err != nil {
+			return nil, err
+		}
+		return newListMatcher(hs), nil
+	case *BoolQuery:
+		return s.boolMatcher(q, allFields)
+	}
+	return nil, fmt.Errorf("%w: unknown query %T", ErrBadQuery, q)
+}
+
+func (s *Searcher) termMatcher(q *TermQuery, allFields []string) (docMatcher, error) {
+	pms, err := s.termPostings(q, allFields)
+	if err != nil {
+		return nil, err
+	}
+
+	subs := make([]docMatcher, len(pms))
+	for i, m := range pms {
+		subs[i] = m
+	}
+	return newOrMatcher(subs), nil
+}
+
+// termPostings 打开 TermQuery 切分出的每个词元在每个字段中的 posting 迭代器.
+// 查询文本是拼音时, 同时在开启了拼音的字段的拼音子字段中查找整个拼音 (全拼或首字母)
+func (s *Searcher) termPostings(q *TermQuery, allFields []string) ([]*postingMatcher, error) {
+	fields := allFields
+	if q.Field != "" {
+		fields = []string{q.Field}
+	}
+	py, isPinyin := pinyinQuery(q.Text)
+
+	var pms []*postingMatcher
+	closeAll := func() {
+		for _, pm := range pms {
+			pm.close()
+		}
+	}
+	open := func(field, text string) error {
+		m, err := s.postingMatcher(field, text)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		if m != nil {
+			pms = append(pms, m)
+		}
+		return nil
+	}
+
+	for _, field := range fields {
+		if isPinyin && strings.HasSuffix(field, PinyinSuffix) {
+			if err := open(field, py); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		terms, err := s.analyze(s.spec, field, q.Text, true)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		for _, term := range terms {
+			if err := open(field, term.Text); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if isPinyin {
+		for _, sub := range pinyinFields(s.spec, fields) {
+			if err := open(sub, py); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return pms, nil
+}
+
+// postingMatcher 词元不存在时返回 nil
+func (s *Searcher) postingMatcher(field, text string) (*postingMatcher, error) {
+	t, err := s.store.LookupToken(field, text)
+	if err == ErrTokenNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	it, err := s.store.PostingListIterator(t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &postingMatcher{t: t, field: field, it: it}
+	if it.Next() {
+		m.pl = it.Posting()
+	}
+	return m, nil
+}
+
+// boolMatcher 有 must 时遍历 must 的交集, should 只追加命中的词元; 否则遍历 should 的并集.
+// 命中 must_not 的文档被跳过.
+func (s *Searcher) boolMatcher(q *BoolQuery, allFields []string) (docMatcher, error) {
+	var all []docMatcher
+	build := func(qs []Query) ([]docMatcher, error) {
+		var ms []docMatcher
+		for _, c := range qs {
+			m, err := s.matcher(c, allFields)
+			if err != nil {
+				return nil, err
+			}
+			ms = append(ms, m)
+			all = append(all, m)
+		}
+		return ms, nil
+	}
+
+	must, err := build(q.Must)
+	if err != nil {
+		closeMatchers(all)
+		return nil, err
+	}
+	should, err := build(q.Should)
+	if err != nil {
+		closeMatchers(all)
+		return nil, err
+	}
+	mustNot, err := build(q.MustNot)
+	if err != nil {
+		closeMatchers(all)
+		return nil, err
+	}
+
+	m := &reqExclMatcher{excl: mustNot}
+	if len(must) > 0 {
+		m.req = newAndMatcher(must)
+		m.opt = should
+	} else {
+		m.req = newOrMatcher(should)
+	}
+	m.skipExcluded()
+	return m, nil
+}
+
+// postingMatcher 遍历一个词元的 posting 迭代器
+type postingMatcher struct {
+	t     *Token
+	field string
+	it    PostingIterator
+	pl    *PostingList
+}
+
+func (m *postingMatcher) docID() uint64 {
+	if m.pl == nil {
+		return noMoreDocs
+	}
+	return m.pl.DocID
+}
+
+func (m *postingMatcher) advance(target uint64) {
+	if m.pl == nil || m.pl.DocID >= target {
+		return
+	}
+	if m.it.Advance(target) {
+		m.pl = m.it.Posting()
+	} else {
+		m.pl = nil
+	}
+}
+
+func (m *postingMatcher) collect(hits []*termHit) []*termHit {
+	return append(hits, &termHit{t: m.t, pl: m.pl.PosList, offsets: m.pl.Offsets, field: m.field, docLen: m.pl.DocLen})
+}
+
+func (m *postingMatcher) close() error {
+	err := m.it.Err()
+	if e := m.it.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// listMatcher 遍历预先计算好的命中文档
+type listMatcher struct {
+	docs []uint64
+	hs   hitSet
+	i    int
+}
+
+func newListMatcher(hs hitSet) *listMatcher {
+	m := &listMatcher{hs: hs}
+	for docID := range hs {
+		m.docs = append(m.docs, docID)
+	}
+	sort.Slice(m.docs, func(i, j int) bool { return m.docs[i] < m.docs[j] })
+	return m
+}
+
+func (m *listMatcher) docID() uint64 {
+	if m.i >= len(m.docs) {
+		return noMoreDocs
+	}
+	return m.docs[m.i]
+}
+
+func (m *listMatcher) advance(target uint64) {
+	if m.docID() >= target {
+		return
+	}
+	rest := m.docs[m.i:]
+	m.i += sort.Search(len(rest), func(j int) bool { return rest[j] >= target })
+}
+
+func (m *listMatcher) collect(hits []*termHit) []*termHit {
+	return append(hits, m.hs[m.docs[m.i]].hitTokens...)
+}
+
+func (m *listMatcher) close() error {
+	return nil
+}
+
+// orMatcher 子查询的并集
+type orMatcher struct {
+	subs []docMatcher
+	doc  uint64
+}
+
+func newOrMatcher(subs []docMatcher) docMatcher {
+	if len(subs) == 1 {
+		return subs[0]
+	}
+	m := &orMatcher{subs: subs}
+	m.update()
+	return m
+}
+
+func (m *orMatcher) update() {
+	m.doc = noMoreDocs
+	for _, sub := range m.subs {
+		if d := sub.docID(); d < m.doc {
+			m.doc = d
+		}
+	}
+}
+
+func (m *orMatcher) docID() uint64 {
+	return m.doc
+}
+
+func (m *orMatcher) advance(target uint64) {
+	if m.doc >= target {
+		return
+	}
+	for _, sub := range m.subs {
+		sub.advance(target)
+	}
+	m.update()
+}
+
+func (m *orMatcher) collect(hits []*termHit) []*termHit {
+	for _, sub := range m.subs {
+		if sub.docID() == m.doc {
+			hits = sub.collect(hits)
+		}
+	}
+	return hits
+}
+
+func (m *orMatcher) close() error {
+	return closeMatchers(m.subs)
+}
+
+// andMatcher 子查询的交集
+type andMatcher struct {
+	subs []docMatcher
+	doc  uint64
+}
+
+func newAndMatcher(subs []docMatcher) docMatcher {
+	if len(subs) == 1 {
+		return subs[0]
+	}
+	m := &andMatcher{subs: subs}
+	m.align(0)
+	return m
+}
+
+// align 将所有子查询移动到第一个 >= target 的公共文档
+func (m *andMatcher) align(target uint64) {
+	for {
+		max := target
+		for _, sub := range m.subs {
+			sub.advance(max)
+			if d := sub.docID(); d > max {
+				max = d
+			}
+		}
+
+		if max == noMoreDocs {
+			m.doc = noMoreDocs
+			return
+		}
+
+		same := true
+		for _, sub := range m.subs {
+			if sub.docID() != max {
+				same = false
+				break
+			}
+		}
+		if same {
+			m.doc = max
+			return
+		}
+		target = max
+	}
+}
+
+func (m *andMatcher) docID() uint64 {
+	return m.doc
+}
+
+func (m *andMatcher) advance(target uint64) {
+	if m.doc >= target {
+		return
+	}
+	m.align(target)
+}
+
+func (m *andMatcher) collect(hits []*termHit) []*termHit {
+	for _, sub := range m.subs {
+		hits = sub.collect(hits)
+	}
+	return hits
+}
+
+func (m *andMatcher) close() error {
+	return closeMatchers(m.subs)
+}
+
+// reqExclMatcher 遍历 req 中不被 excl 命中的文档, opt 只追加命中的词元
+type reqExclMatcher struct {
+	req  docMatcher
+	opt  []docMatcher
+	excl []docMatcher
+}
+
+func (m *reqExclMatcher) skipExcluded() {
+	for {
+		d := m.req.docID()
+		if d == noMoreDocs {
+			return
+		}
+
+		excluded := false
+		for _, e := range m.excl {
+			e.advance(d)
+			if e.docID() == d {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			return
+		}
+		m.req.advance(d + 1)
+	}
+}
+
+func (m *reqExclMatcher) docID() uint64 {
+	return m.req.docID()
+}
+
+func (m *reqExclMatcher) advance(target uint64) {
+	if m.req.docID() >= target {
+		return
+	}
+	m.req.advance(target)
+	m.skipExcluded()
+}
+
+func (m *reqExclMatcher) collect(hits []*termHit) []*termHit {
+	d := m.req.docID()
+	hits = m.req.collect(hits)
+	for _, o := range m.opt {
+		o.advance(d)
+		if o.docID() == d {
+			hits = o.collect(hits)
+		}
+	}
+	return hits
+}
+
+func (m *reqExclMatcher) close() error {
+	err := m.req.close()
+	if e := closeMatchers(m.opt); err == nil {
+		err = e
+	}
+	if e := closeMatchers(m.excl); err == nil {
+		err = e
+	}
+	return err
+}
+
+// hitSet 查询命中的文档
+type hitSet map[uint64]*Hit
+
+func (hs hitSet) add(docID uint64, th ...*termHit) {
+	if h, ok := hs[docID]; ok {
+		h.hitTokens = append(h.hitTokens, th...)
+	} else {
+		hs[docID] = &Hit{docID: docID, hitTokens: th}
+	}
+}
+
+// matchPhrase 对短语中的每个词元求 posting list 的交集, 再在文档内比较位置.
+// 短语不使用搜索模式分词, 避免切出重叠的子词. 拼音短语在拼音子字段中按单个词元匹配.
+// 短语的 DocCount / PosCount (idf 使用) 需要遍历全部候选文档才能确定, 因此一次求出所有命中的
+// 文档放入 hitSet, 而不是像词元一样按 docID 流式匹配. 命中很多的短语会占用较多内存
+func (s *Searcher) matchPhrase(q *PhraseQuery, allFields []string) (hitSet, error) {
+	fields := allFields
+	if q.Field != "" {
+		fields = []string{q.Field}
+	}
+
+	docs := make(hitSet)
+	for _, field := range fields {
+		all, err := s.analyze(s.spec, field, q.Text, false)
+		if err != nil {
+			return nil, err
+		}
+		terms, offsets := phraseTerms(all)
+		if len(terms) == 0 {
+			continue
+		}
+
+		// 先按 docID 求交集 (借助迭代器的跳表), 再比较位置
+		var subs []docMatcher
+		for _, term := range terms {
+			m, err := s.postingMatcher(field, term.Text)
+			if err != nil {
+				closeMatchers(subs)
+				return nil, err
+			}
+			if m == nil {
+				closeMatchers(subs)
+				subs = nil
+				break
+			}
+			subs = append(subs, m)
+		}
+		if subs == nil {
+			continue
+		}
+
+		t := &Token{Field: field, Value: q.Text}
+		pos := make([][]int, len(subs))
+		pls := make([]*PostingList, len(subs))
+		and := newAndMatcher(subs)
+		for ; and.docID() != noMoreDocs; and.advance(and.docID() + 1) {
+			for i, sub := range subs {
+				pls[i] = sub.(*postingMatcher).pl
+				pos[i] = pls[i].PosList
+			}
+
+			starts, freq := matchPositions(pos, offsets, q.Slop)
+			if len(starts) == 0 {
+				continue
+			}
+
+			t.DocCount++
+			t.PosCount += len(starts)
+			docLen := subs[0].(*postingMatcher).pl.DocLen
+			docs.add(and.docID(), &termHit{
+				t: t, pl: starts, field: field, docLen: docLen, phraseFreq: freq,
+				offsets: phraseOffsets(pls, starts, offsets[len(offsets)-1]+1+q.Slop),
+			})
+		}
+
+		if err := and.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 拼音短语如 "bei jing" 作为整体在拼音子字段中查找
+	if py, ok := pinyinQuery(q.Text); ok {
+		for _, sub := range pinyinFields(s.spec, fields) {
+			m, err := s.postingMatcher(sub, py)
+			if err != nil {
+				return nil, err
+			}
+			if m == nil {
+				continue
+			}
+			for ; m.docID() != noMoreDocs; m.advance(m.docID() + 1) {
+				docs.add(m.docID(), m.collect(nil)...)
+			}
+			if err := m.close(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+// phraseTerms 返回短语的词元及其相对第一个词元的位置偏移. 同一位置只保留第一个词元,
+// 同义词只在索引时展开即可匹配
+func phraseTerms(all []Term) ([]Term, []int) {
+	var (
+		terms   []Term
+		offsets []int
+	)
+	for i, t := range all {
+		if i > 0 && t.Pos == all[i-1].Pos {
+			continue
+		}
+		terms = append(terms, t)
+		offsets = append(offsets, t.Pos-all[0].Pos)
+	}
+	return terms, offsets
+}
+
+// phraseOffsets 返回位于匹配窗口 [start, start+width) 内的词元的字节偏移, 用于高亮.
+// 任意一个词元没有保存偏移时返回 nil
+func phraseOffsets(pls []*PostingList, starts []int, width int) []Offset {
+	var offsets []Offset
+	for _, pl := range pls {
+		if len(pl.Offsets) != len(pl.PosList) {
+			return nil
+		}
+		for j, p := range pl.PosList {
+			for _, start := range starts {
+				if p >= start && p < start+width {
+					offsets = append(offsets, pl.Offsets[j])
+					break
+				}
+			}
+		}
+	}
+	return offsets
+}
+
+// matchPositions 查找各词元位置减去其在短语中的偏移后, 最大值与最小值之差不超过 slop 的组合,
+// 返回每次匹配的起始位置以及按偏差加权的词频
+func matchPositions(pos [][]int, offsets []int, slop int) ([]int, float64) {
+	var (
+		starts []int
+		freq   float64
+	)
+
+	for _, p := range pos {
+		if len(p) == 0 {
+			return nil, 0
+		}
+	}
+
+	idx := make([]int, len(pos))
+	for {
+		minI, min, max := 0, 0, 0
+		for i, p := range pos {
+			v := p[idx[i]] - offsets[i]
+			if i == 0 || v < min {
+				minI, min = i, v
+			}
+			if i == 0 || v > max {
+				max = v
+			}
+		}
+
+		if d := max - min; d <= slop {
+			starts = append(starts, min)
+			freq += 1 / float64(d+1)
+		}
+
+		idx[minI]++
+		if idx[minI] >= len(pos[minI]) {
+			return starts, freq
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package tns
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MergePolicy 分层 (tiered) 合并策略.
+//
+// 段按文件大小分层: 不超过 MinSegmentSize 的段属于第 0 层, 第 n 层的段大小不超过
+// MinSegmentSize * MergeFactor^n. 某一层的段数超过 MaxSegmentsPerTier 时,
+// 将该层最小的 MergeFactor 个段合并为一个, 合并后的段通常落入更高一层.
+// MinSegmentSize 不大于 0 时使用 DefaultMergePolicy 的值.
+type MergePolicy struct {
+	MergeFactor        int
+	MaxSegmentsPerTier int
+	MinSegmentSize     int64
+}
+
+var DefaultMergePolicy = MergePolicy{
+	MergeFactor:        10,
+	MaxSegmentsPerTier: 10,
+	MinSegmentSize:     8 << 20,
+}
+
+func (p *MergePolicy) tier(size int64) int {
+	factor := int64(p.MergeFactor)
+	if factor < 2 {
+		factor = 2
+	}
+
+	limit := p.MinSegmentSize
+	if limit <= 0 {
+		limit = DefaultMergePolicy.MinSegmentSize
+	}
+
+	t := 0
+	for ; size > limit; limit *= factor {
+		t++
+	}
+	return t
+}
+
+// FindMerge 返回下一组需要合并的段 (sizes 的下标), 没有需要合并的段时返回 nil
+func (p *MergePolicy) FindMerge(sizes []int64) []int {
+	tiers := make(map[int][]int)
+	maxTier := 0
+	for i, size := range sizes {
+		t := p.tier(size)
+		tiers[t] = append(tiers[t], i)
+		if t > maxTier {
+			maxTier = t
+		}
+	}
+
+	n := p.MergeFactor
+	if n < 2 {
+		n = 2
+	}
+
+	// 从低层开始, 小段合并代价最低
+	for t := 0; t <= maxTier; t++ {
+		segs := tiers[t]
+		if len(segs) <= p.MaxSegmentsPerTier || len(segs) < 2 {
+			continue
+		}
+
+		sort.SliceStable(segs, func(i, j int) bool { return sizes[segs[i]] < sizes[segs[j]] })
+		if len(segs) > n {
+			segs = segs[:n]
+		}
+		return segs
+	}
+
+	return nil
+}
+
+func (s *BoltStore) MergeSegments(p *MergePolicy) error {
+	s.mergeMu.Lock()
+	defer s.mergeMu.Unlock()
+
+	if err := s.check(nil); err != nil {
+		return err
+	}
+
+	for {
+		s.mu.RLock()
+		segments := make([]*storeSegment, len(s.segments))
+		copy(segments, s.segments)
+		s.mu.RUnlock()
+
+		sizes := make([]int64, len(segments))
+		for i, seg := range segments {
+			sizes[i] = seg.Size()
+		}
+
+		picked := p.FindMerge(sizes)
+		if len(picked) == 0 {
+			return nil
+		}
+
+		merging := make([]*storeSegment, len(picked))
+		for i, idx := range picked {
+			merging[i] = segments[idx]
+		}
+
+		if err := s.mergeSegments(merging); err != nil {
+			return err
+		}
+	}
+}
+
+// mergeSegments 将 segs 合并为一个新段, 合并时丢弃已删除文档的 posting
+func (s *BoltStore) mergeSegments(segs []*storeSegment) error {
+	start := time.Now()
+
+	deleted := make(map[uint64]bool)
+	err := s.view(func(tx *bolt.Tx) error {
+		return s.bucket(tx, deletedBucket).ForEach(func(k, v []byte) error {
+			deleted[btoi(k)] = true
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	var seq uint64
+	err = s.update(func(tx *bolt.Tx) error {
+		seq, err = s.bucket(tx, segmentBucket).NextSequence()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%08d.seg", seq)
+	path := filepath.Join(s.segmentDir, name)
+
+	files := make([]*InvertFile, len(segs))
+	for i, seg := range segs {
+		files[i] = seg.InvertFile
+	}
+	if err := mergeInvertFiles(path, files, deleted); err != nil {
+		return err
+	}
+
+	f, err := OpenInvertFile(path)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[uint64]bool)
+	for _, seg := range segs {
+		merged[seg.seq] = true
+	}
+
+	// 替换 manifest 与内存中的段列表, 持有写锁期间不会有正在进行的扫描
+	err = s.update(func(tx *bolt.Tx) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		b := s.bucket(tx, segmentBucket)
+		for seq := range merged {
+			if err := b.Delete(itob(seq)); err != nil {
+				return err
+			}
+		}
+		if err := b.Put(itob(seq), []byte(name)); err != nil {
+			return err
+		}
+
+		// 所有段都参与了合并且 ii bucket 中没有 posting 时, 已删除文档的 posting
+		// 已被彻底清除, tombstone 不再需要
+		purge := len(segs) == len(s.segments) && s.bucket(tx, iiBucket).Stats().KeyN == 0
+		if purge {
+			del := s.bucket(tx, deletedBucket)
+			for id := range deleted {
+				if err := del.Delete(itob(id)); err != nil {
+					return err
+				}
+			}
+		}
+
+		var segments []*storeSegment
+		for _, seg := range s.segments {
+			if !merged[seg.seq] {
+				segments = append(segments, seg)
+			}
+		}
+		s.segments = append(segments, newStoreSegment(seq, f))
+
+		// 替换而不是原地修改, 正在遍历旧段的迭代器仍然使用原来的集合
+		if purge {
+			remain := make(map[uint64]bool)
+			for id := range s.deleted {
+				if !deleted[id] {
+					remain[id] = true
+				}
+			}
+			s.deleted = remain
+		}
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	for _, seg := range segs {
+		seg.obsolete = true
+		seg.release()
+	}
+
+	log.Printf("%d segments merged into %s (%d bytes) in %v\n", len(segs), name, f.Size(), time.Now().Sub(start))
+	return nil
+}
+
+// mergeInvertFiles 按 tokenID 多路归并 files, 写入 path
+func mergeInvertFiles(path string, files []*InvertFile, deleted map[uint64]bool) error {
+	w, err := newInvertFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer w.abort()
+
+	cursors := make([]int, len(files))
+	var pls []*PostingList
+
+	for {
+		var (
+			tokenID uint64
+			found   bool
+		)
+		for i, f := range files {
+			if cursors[i] < len(f.terms) {
+				if id := f.terms[cursors[i]].tokenID; !found || id < tokenID {
+					tokenID, found = id, true
+				}
+			}
+		}
+		if !found {
+			break
+		}
+
+		pls = pls[:0]
+		for i, f := range files {
+			if cursors[i] == len(f.terms) || f.terms[cursors[i]].tokenID != tokenID {
+				continue
+			}
+
+			err := f.scanTerm(cursors[i], func(pl *PostingList) {
+				if !deleted[pl.DocID] {
+					pls = append(pls, pl)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			cursors[i]++
+		}
+
+		sort.Slice(pls, func(i, j int) bool { return pls[i].DocID < pls[j].DocID })
+		if err := w.addTerm(tokenID, pls); err != nil {
+			return err
+		}
+	}
+
+	return w.commit()
+}
@@ -0,0 +1,111 @@
+package tns
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMergePolicyFindMerge(t *testing.T) {
+	p := &MergePolicy{MergeFactor: 4, MaxSegmentsPerTier: 3, MinSegmentSize: 100}
+
+	for size, tier := range map[int64]int{0: 0, 100: 0, 101: 1, 400: 1, 401: 2, 1600: 2, 1601: 3} {
+		if got := p.tier(size); got != tier {
+			t.Fatalf("tier(%d) = %d, want %d", size, got, tier)
+		}
+	}
+
+	for _, c := range []struct {
+		sizes []int64
+		want  []int
+	}{
+		{[]int64{10, 50, 20}, nil},
+		// 第 0 层超过 3 个段, 合并其中最小的 4 个
+		{[]int64{10, 50, 20, 30, 90}, []int{0, 2, 3, 1}},
+		// 第 0 层未超过, 合并第 1 层
+		{[]int64{300, 10, 200, 150, 20, 250}, []int{3, 2, 5, 0}},
+		{[]int64{1000, 1000, 1000, 300, 10}, nil},
+	} {
+		if got := p.FindMerge(c.sizes); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("FindMerge(%v) = %v, want %v", c.sizes, got, c.want)
+		}
+	}
+
+	// MinSegmentSize 为 0 时使用默认值, 不能死循环
+	p = &MergePolicy{MergeFactor: 4, MaxSegmentsPerTier: 4}
+	if got := p.FindMerge([]int64{1, 2, 3, 4, 5}); len(got) != 4 {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestMergeSegments(t *testing.T) {
+	s := testStore(t)
+	for d := uint64(1); d <= 5; d++ {
+		err := s.AddSegment(map[uint64]map[uint64]*PostingList{
+			1:      {d: {TokenID: 1, DocID: d, DocLen: 2, PosList: []int{0}}},
+			d + 10: {d: {TokenID: d + 10, DocID: d, DocLen: 2, PosList: []int{1}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.MergeSegments(&MergePolicy{MergeFactor: 4, MaxSegmentsPerTier: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.(*BoltStore).segments); n != 2 {
+		t.Fatalf("got %d segments, want 2", n)
+	}
+
+	var ids []uint64
+	s.ScanPostingListByToken(1, func(pl *PostingList) { ids = append(ids, pl.DocID) })
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if !reflect.DeepEqual(ids, []uint64{1, 2, 3, 4, 5}) {
+		t.Fatalf("postings of token 1: %v", ids)
+	}
+}
+
+func TestMergePurgesDeleted(t *testing.T) {
+	s := testStore(t)
+	for d := uint64(1); d <= 4; d++ {
+		err := s.AddSegment(map[uint64]map[uint64]*PostingList{
+			1: {d: {TokenID: 1, DocID: d, DocLen: 1, PosList: []int{0}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.delDoc(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// 合并全部段
+	if err := s.MergeSegments(&MergePolicy{MergeFactor: 4, MaxSegmentsPerTier: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	bs := s.(*BoltStore)
+	if len(bs.segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(bs.segments))
+	}
+
+	// 段文件中不再有已删除文档的 posting
+	var ids []uint64
+	bs.segments[0].ScanPostingList(func(pl *PostingList) { ids = append(ids, pl.DocID) })
+	if !reflect.DeepEqual(ids, []uint64{1, 3, 4}) {
+		t.Fatalf("merged postings: %v", ids)
+	}
+
+	// tombstone 已清除
+	if len(bs.deleted) != 0 {
+		t.Fatalf("deleted: %v", bs.deleted)
+	}
+	bs.db.View(func(tx *bolt.Tx) error {
+		if n := bs.bucket(tx, deletedBucket).Stats().KeyN; n != 0 {
+			t.Fatalf("%d tombstones left", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package tns
+
+import (
+	"sort"
+	"unicode"
+)
+
+// NGramTokenizer 不依赖词典的分词器: 连续的中日韩文字切分为重叠的 N 元组 (北京大学 -> 北京 京大 大学),
+// 不足 N 个字时整体作为一个词元; 连续的字母与数字作为一个词元; 其余字符被忽略.
+// 未登录词 (人名, 产品名) 也能被搜索到, 代价是索引更大, 也会命中跨词的 N 元组
+type NGramTokenizer struct {
+	N int
+}
+
+// NewNGramTokenizer n 小于 1 时使用二元组
+func NewNGramTokenizer(n int) *NGramTokenizer {
+	if n < 1 {
+		n = 2
+	}
+	return &NGramTokenizer{N: n}
+}
+
+// isCJK 中日韩文字 (包括片假名长音符 ー), 按 N 元组切分
+func isCJK(r rune) bool {
+	return r == 'ー' || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func (t *NGramTokenizer) Tokenzie(text string, searchMode bool) []Term {
+	var (
+		terms []Term
+		// cjk 当前中日韩文字串中每个字的起始偏移
+		cjk  []int
+		word = -1
+	)
+	flushCJK := func(end int) {
+		if len(cjk) == 0 {
+			return
+		}
+		if len(cjk) <= t.N {
+			terms = append(terms, Term{Text: text[cjk[0]:end], Start: cjk[0], End: end})
+		} else {
+			for i := 0; i+t.N <= len(cjk); i++ {
+				e := end
+				if i+t.N < len(cjk) {
+					e = cjk[i+t.N]
+				}
+				terms = append(terms, Term{Text: text[cjk[i]:e], Start: cjk[i], End: e})
+			}
+		}
+		cjk = cjk[:0]
+	}
+	flushWord := func(end int) {
+		if word >= 0 {
+			terms = append(terms, Term{Text: text[word:end], Start: word, End: end})
+			word = -1
+		}
+	}
+
+	for i, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord(i)
+			cjk = append(cjk, i)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK(i)
+			if word < 0 {
+				word = i
+			}
+		default:
+			flushCJK(i)
+			flushWord(i)
+		}
+	}
+	flushCJK(len(text))
+	flushWord(len(text))
+
+	return terms
+}
+
+// MultiTokenizer 组合多个分词器, 例如 MultiTokenizer{NewNGramTokenizer(2), jieba}, 召回不依赖词典的覆盖.
+// 第一个分词器的词元决定位置, 其余分词器的词元与起始偏移不大于它的最后一个主词元位置相同 (类似同义词),
+// 短语查询只使用主词元匹配, 不受其余分词器按上下文切分的影响. 相同位置的相同词元只保留一个
+type MultiTokenizer []Tokenizer
+
+func (m MultiTokenizer) Tokenzie(text string, searchMode bool) []Term {
+	if len(m) == 0 {
+		return nil
+	}
+
+	terms := m[0].Tokenzie(text, searchMode)
+	for j := range terms {
+		terms[j].Pos = j
+		terms[j].End = terms[j].end()
+	}
+	primary := len(terms)
+
+	for _, t := range m[1:] {
+		for _, term := range t.Tokenzie(text, searchMode) {
+			term.End = term.end()
+			// 起始偏移不大于 term.Start 的最后一个主词元
+			k := sort.Search(primary, func(j int) bool { return terms[j].Start > term.Start })
+			term.Pos = 0
+			if k > 0 {
+				term.Pos = terms[k-1].Pos
+			}
+			terms = append(terms, term)
+		}
+	}
+
+	// 同一位置主词元在前
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].Pos < terms[j].Pos })
+
+	out := terms[:0]
+	pos, seen := -1, make(map[string]bool)
+	for _, t := range terms {
+		if t.Pos != pos {
+			pos, seen = t.Pos, make(map[string]bool)
+		}
+		if !seen[t.Text] {
+			seen[t.Text] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// positioned 分词器是否自己设置了词元的位置
+func positioned(t Tokenizer) bool {
+	_, ok := t.(MultiTokenizer)
+	return ok
+}
@@ -0,0 +1,154 @@
+package tns
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// PinyinSuffix 拼音子字段名的后缀, FieldSpec.Pinyin 为 true 的字段 Title 的拼音写入 Title.pinyin
+const PinyinSuffix = ".pinyin"
+
+//go:embed zh_pinyin.txt
+var zhPinyin string
+
+//go:embed zh_pinyin_phrases.txt
+var zhPinyinPhrases string
+
+var (
+	pinyinOnce  sync.Once
+	pinyinTable map[rune]string
+	// pinyinPhrases 多音字的词语读音, pinyinMaxPhrase 最长词语的字数
+	pinyinPhrases   map[string][]string
+	pinyinMaxPhrase int
+	pinyinT2S       *ChineseFilter
+)
+
+func loadPinyin() {
+	pinyinTable = make(map[rune]string)
+	for _, line := range strings.Split(zhPinyin, "\n") {
+		py, chars, found := strings.Cut(line, " ")
+		if !found || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, r := range strings.TrimSpace(chars) {
+			pinyinTable[r] = py
+		}
+	}
+
+	pinyinPhrases = make(map[string][]string)
+	for _, line := range strings.Split(zhPinyinPhrases, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pinyinPhrases[fields[0]] = fields[1:]
+		if n := len(fields) - 1; n > pinyinMaxPhrase {
+			pinyinMaxPhrase = n
+		}
+	}
+
+	pinyinT2S = NewChineseFilter(TraditionalToSimplified)
+}
+
+// Pinyin 返回 text 中每个汉字的拼音 (不带声调). 多音字按正向最大匹配优先使用词语读音 (银行 -> yin hang),
+// 繁体词语按对应的简体词语查找, 其余使用单字最常用的读音. 不在拼音表中的字符被跳过, 没有任何拼音时 ok 为 false
+func Pinyin(text string) (syllables []string, ok bool) {
+	pinyinOnce.Do(loadPinyin)
+
+	runes := []rune(text)
+	simp := []rune(pinyinT2S.Convert(text))
+	for i := 0; i < len(runes); {
+		n := len(runes) - i
+		if n > pinyinMaxPhrase {
+			n = pinyinMaxPhrase
+		}
+		for ; n > 1; n-- {
+			py, found := pinyinPhrases[string(runes[i:i+n])]
+			if !found {
+				py, found = pinyinPhrases[string(simp[i:i+n])]
+			}
+			if found {
+				syllables = append(syllables, py...)
+				break
+			}
+		}
+		if n > 1 {
+			i += n
+			continue
+		}
+
+		if py, found := pinyinTable[runes[i]]; found {
+			syllables = append(syllables, py)
+		}
+		i++
+	}
+	return syllables, len(syllables) > 0
+}
+
+// PinyinFilter 把汉字词元替换为全拼 (北京 -> beijing) 与首字母 (bj) 两个词元, 位置与偏移与原词元相同.
+// 单字只保留全拼, 词元中不在拼音表中的字符被跳过, 没有汉字的词元被删除. 用于拼音子字段
+type PinyinFilter struct{}
+
+func (PinyinFilter) Filter(terms []Term) []Term {
+	var out []Term
+	for _, t := range terms {
+		syllables, ok := Pinyin(t.Text)
+		if !ok {
+			continue
+		}
+
+		// 替换文本前确定原词元的结束偏移
+		t.End = t.end()
+		full := t
+		full.Text = strings.Join(syllables, "")
+		out = append(out, full)
+
+		if len(syllables) > 1 {
+			var initials strings.Builder
+			for _, s := range syllables {
+				initials.WriteByte(s[0])
+			}
+			abbr := t
+			abbr.Text = initials.String()
+			out = append(out, abbr)
+		}
+	}
+	return out
+}
+
+// pinyinQuery 查询文本只包含字母, 空白与隔音符号时视为拼音输入, 返回去掉空白与隔音符号后的小写拼音,
+// 如 "Bei Jing" -> "beijing"
+func pinyinQuery(text string) (string, bool) {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLetter(r):
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == '\'':
+		default:
+			return "", false
+		}
+	}
+	return b.String(), b.Len() > 0
+}
+
+// indexedFields 返回字段 name 及其拼音子字段
+func indexedFields(spec *IndexSpec, name string) []string {
+	return append([]string{name}, pinyinFields(spec, []string{name})...)
+}
+
+// pinyinFields 返回 fields 中开启了拼音的字段的拼音子字段
+func pinyinFields(spec *IndexSpec, fields []string) []string {
+	var subs []string
+	if spec == nil {
+		return nil
+	}
+	for _, name := range fields {
+		if f := spec.Field(name); f != nil && f.Pinyin {
+			subs = append(subs, name+PinyinSuffix)
+		}
+	}
+	return subs
+}
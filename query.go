@@ -0,0 +1,306 @@
+package tns
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query 查询语法树
+//
+// 查询语法:
+//
+//	北京 AND (地铁 OR 公交) -广告
+//	+Title:北京 Text:(地铁 公交) NOT 广告
+//	"中华 人民 共和国" "a b"~5
+//
+// 空白分隔的子句默认为 should, "+" / AND 表示必须匹配, "-" / NOT 表示必须不匹配,
+// "field:" 限定子句搜索的字段, 引号内为短语, "~N" 为短语允许的位置偏差.
+type Query interface {
+	String() string
+}
+
+// TermQuery 匹配包含 Text 的文档, Text 经分词器切分后任意一个词元命中即可.
+// Field 为空时搜索全部字段.
+type TermQuery struct {
+	Field string
+	Text  string
+}
+
+// PhraseQuery 匹配按顺序相邻出现 Text 中所有词元的文档. Slop 为允许的位置偏差,
+// 偏差越小的匹配得分越高.
+type PhraseQuery struct {
+	Field string
+	Text  string
+	Slop  int
+}
+
+// BoolQuery 文档需匹配全部 Must 且不匹配任何 MustNot, 没有 Must 时至少匹配一个 Should.
+// 有 Must 时 Should 只影响打分.
+type BoolQuery struct {
+	Must    []Query
+	Should  []Query
+	MustNot []Query
+}
+
+var ErrBadQuery = errors.New("bad query")
+
+func (q *TermQuery) String() string {
+	if q.Field == "" {
+		return q.Text
+	}
+	return q.Field + ":" + q.Text
+}
+
+func (q *PhraseQuery) String() string {
+	s := strconv.Quote(q.Text)
+	if q.Field != "" {
+		s = q.Field + ":" + s
+	}
+	if q.Slop > 0 {
+		s += "~" + strconv.Itoa(q.Slop)
+	}
+	return s
+}
+
+func (q *BoolQuery) String() string {
+	var clauses []string
+	add := func(prefix string, qs []Query) {
+		for _, c := range qs {
+			s := c.String()
+			if _, ok := c.(*BoolQuery); ok {
+				s = "(" + s + ")"
+			}
+			clauses = append(clauses, prefix+s)
+		}
+	}
+	add("+", q.Must)
+	add("", q.Should)
+	add("-", q.MustNot)
+	return strings.Join(clauses, " ")
+}
+
+type occur int
+
+const (
+	occurShould occur = iota
+	occurMust
+	occurMustNot
+)
+
+type queryParser struct {
+	toks []string
+	pos  int
+
+	// field 将查询中的字段名解析为索引中的字段名, 不是字段时 "xx:" 作为普通文本
+	field func(name string) (string, bool)
+}
+
+// ParseQuery 解析查询, fields 为索引中的字段名, 字段名忽略大小写
+func ParseQuery(q string, fields []string) (Query, error) {
+	p := &queryParser{
+		toks: lexQuery(q),
+		field: func(name string) (string, bool) {
+			for _, f := range fields {
+				if strings.EqualFold(f, name) {
+					return f, true
+				}
+			}
+			return "", false
+		},
+	}
+	return p.parse()
+}
+
+// lexQuery 按空白和括号切分查询, 引号内的空白和括号不切分
+func lexQuery(q string) []string {
+	var toks []string
+	start := -1
+	quoted := false
+	for i, r := range q {
+		if r == '"' {
+			quoted = !quoted
+		}
+
+		if !quoted && (unicode.IsSpace(r) || r == '(' || r == ')') {
+			if start >= 0 {
+				toks = append(toks, q[start:i])
+				start = -1
+			}
+			if r == '(' || r == ')' {
+				toks = append(toks, string(r))
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, q[start:])
+	}
+	return toks
+}
+
+func (p *queryParser) next() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	tok := p.toks[p.pos]
+	p.pos++
+	return tok
+}
+
+func (p *queryParser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+func (p *queryParser) parse() (Query, error) {
+	q, err := p.parseGroup("", false)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// parseGroup 解析一组子句直到 ")" 或结尾. 与 Lucene 一致, "a AND b" 将两侧的子句都变为 must,
+// OR 与默认的空白分隔相同.
+func (p *queryParser) parseGroup(field string, inParen bool) (Query, error) {
+	var (
+		clauses []Query
+		occurs  []occur
+		conj    string
+		mod     = occurShould
+		hasMod  bool
+	)
+
+	for {
+		tok := p.next()
+		switch tok {
+		case "":
+			if inParen {
+				return nil, fmt.Errorf("%w: missing )", ErrBadQuery)
+			}
+		case ")":
+			if !inParen {
+				return nil, fmt.Errorf("%w: unexpected )", ErrBadQuery)
+			}
+		case "AND", "OR":
+			if len(clauses) == 0 || conj != "" || hasMod {
+				return nil, fmt.Errorf("%w: unexpected %s", ErrBadQuery, tok)
+			}
+			conj = tok
+			continue
+		case "NOT", "+", "-":
+			if hasMod {
+				return nil, fmt.Errorf("%w: unexpected %s", ErrBadQuery, tok)
+			}
+			mod, hasMod = occurMustNot, true
+			if tok == "+" {
+				mod = occurMust
+			}
+			continue
+		}
+
+		if tok == "" || tok == ")" {
+			if conj != "" || hasMod {
+				return nil, fmt.Errorf("%w: dangling operator", ErrBadQuery)
+			}
+			break
+		}
+
+		if !hasMod && len(tok) > 1 && (tok[0] == '+' || tok[0] == '-') {
+			mod, hasMod = occurMustNot, true
+			if tok[0] == '+' {
+				mod = occurMust
+			}
+			tok = tok[1:]
+		}
+
+		q, err := p.parseClause(tok, field)
+		if err != nil {
+			return nil, err
+		}
+
+		if conj == "AND" {
+			if occurs[len(occurs)-1] == occurShould {
+				occurs[len(occurs)-1] = occurMust
+			}
+			if mod == occurShould {
+				mod = occurMust
+			}
+		}
+
+		clauses = append(clauses, q)
+		occurs = append(occurs, mod)
+		conj, mod, hasMod = "", occurShould, false
+	}
+
+	if len(clauses) == 1 && occurs[0] != occurMustNot {
+		return clauses[0], nil
+	}
+
+	bq := &BoolQuery{}
+	for i, q := range clauses {
+		switch occurs[i] {
+		case occurMust:
+			bq.Must = append(bq.Must, q)
+		case occurMustNot:
+			bq.MustNot = append(bq.MustNot, q)
+		default:
+			bq.Should = append(bq.Should, q)
+		}
+	}
+	return bq, nil
+}
+
+func (p *queryParser) parseClause(tok, field string) (Query, error) {
+	if tok == "(" {
+		return p.parseGroup(field, true)
+	}
+
+	if i := strings.Index(tok, ":"); i > 0 {
+		if f, ok := p.field(tok[:i]); ok {
+			tok = tok[i+1:]
+			if tok == "" && p.peek() == "(" {
+				p.next()
+				return p.parseGroup(f, true)
+			}
+			field = f
+		}
+	}
+
+	if strings.HasPrefix(tok, `"`) {
+		return parsePhrase(tok, field)
+	}
+
+	if tok == "" || strings.Contains(tok, `"`) {
+		return nil, fmt.Errorf("%w: bad term %q", ErrBadQuery, tok)
+	}
+	return &TermQuery{Field: field, Text: tok}, nil
+}
+
+// parsePhrase 解析 "text" 或 "text"~slop
+func parsePhrase(tok, field string) (Query, error) {
+	end := strings.Index(tok[1:], `"`) + 1
+	if end == 0 {
+		return nil, fmt.Errorf("%w: missing \"", ErrBadQuery)
+	}
+
+	q := &PhraseQuery{Field: field, Text: tok[1:end]}
+	if rest := tok[end+1:]; rest != "" {
+		slop, err := strconv.Atoi(strings.TrimPrefix(rest, "~"))
+		if err != nil || rest[0] != '~' || slop < 0 {
+			return nil, fmt.Errorf("%w: bad phrase slop %q", ErrBadQuery, rest)
+		}
+		q.Slop = slop
+	}
+
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, fmt.Errorf("%w: empty phrase", ErrBadQuery)
+	}
+	return q, nil
+}
@@ -0,0 +1,39 @@
+package tns_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zhaoyao/tns"
+)
+
+func TestParseQuery(t *testing.T) {
+	fields := []string{"Title", "Text"}
+	for q, want := range map[string]string{
+		"北京 地铁":                  "北京 地铁",
+		"北京 AND (地铁 OR 公交) -广告":  "+北京 +(地铁 公交) -广告",
+		"+title:北京 Text:(地铁 公交)": "+Title:北京 (Text:地铁 Text:公交)",
+		"a AND b OR c":           "+a +b c",
+		"a NOT b":                "a -b",
+		"-(a b) c":               "c -(a b)",
+		"http://x.com":           "http://x.com",
+		"Text:(a Title:b) AND c": "+(Text:a Title:b) +c",
+		"北京":                     "北京",
+		`"中华 人民 共和国"`:            `"中华 人民 共和国"`,
+		`Title:"a (b)"~5 -c`:     `Title:"a (b)"~5 -c`,
+	} {
+		got, err := tns.ParseQuery(q, fields)
+		if err != nil {
+			t.Fatalf("%q: %v", q, err)
+		}
+		if got.String() != want {
+			t.Fatalf("%q: got %q, want %q", q, got.String(), want)
+		}
+	}
+
+	for _, q := range []string{"(a b", "a)", "AND a", "a OR", "a NOT", "a AND OR b", "Title:", `"a b`, `"a"~x`, `""`, `a"b`} {
+		if _, err := tns.ParseQuery(q, fields); !errors.Is(err, tns.ErrBadQuery) {
+			t.Fatalf("%q: expect ErrBadQuery, got %v", q, err)
+		}
+	}
+}
@@ -1,193 +1,648 @@
 package tns
 
 import (
+	"container/heap"
 	"fmt"
 	"log"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Searcher struct {
-	ii    *InvertIndex
-	t     Tokenizer
+	ii *InvertIndex
+	analysis
 	store Store
+
+	// spec 索引的 schema, 查询按字段定义切分, 与索引时一致. 没有 schema 时为 nil
+	spec *IndexSpec
 }
 
 type termHit struct {
-	t  *Token
-	pl []int
+	t      *Token
+	pl     []int
+	field  string
+	docLen int // 字段长度
+
+	// offsets 命中词元的字节偏移, 用于高亮, 没有保存偏移时为空
+	offsets []Offset
+
+	// phraseFreq 短语的词频, 每次匹配按位置偏差计 1/(偏差+1)
+	phraseFreq float64
 }
 
-func NewSearcher(ii *InvertIndex, t Tokenizer, store Store) *Searcher {
-	return &Searcher{
-		ii:    ii,
-		t:     t,
-		store: store,
+// freq 词频, 短语使用按偏差加权后的词频
+func (th *termHit) freq() float64 {
+	if th.phraseFreq > 0 {
+		return th.phraseFreq
+	}
+	return float64(len(th.pl))
+}
+
+// name 词元在解释中的名字, 如 Text:北京
+func (th *termHit) name() string {
+	if th.field == "" {
+		return th.t.Value
 	}
+	return th.field + ":" + th.t.Value
+}
+
+// NewSearcher 创建 Searcher, 字段通过 FieldSpec.Analyzer 引用的分词器需要用 AddAnalyzer 注册.
+// 索引的 schema 只在创建时读取一次, 没有 schema 的默认索引 spec 为 nil
+func NewSearcher(ii *InvertIndex, t Tokenizer, store Store) (*Searcher, error) {
+	spec, err := store.GetIndexSpec(store.IndexName())
+	if err == ErrIndexNotFound && store.IndexName() == "" {
+		spec, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Searcher{
+		ii:       ii,
+		analysis: newAnalysis(t),
+		store:    store,
+		spec:     spec,
+	}, nil
 }
 
 type Hit struct {
 	docID     uint64
-	docLen    int
 	hitTokens []*termHit
 
 	Doc  *Document
 	Term string
 	//PosList []int
 	Score   float64
-	Explain string
+	Explain *Explanation
+
+	// Highlights 字段名 -> 高亮片段, 只在设置了 SearchOptions.Highlight 时返回
+	Highlights map[string][]string
 }
 
 type TopHits struct {
-	Total    int
-	Duration time.Duration
-	Hits     []*Hit
+	// Total 命中的文档数, TotalLowerBound 为 true 时动态剪枝跳过了部分文档, Total 只是下界
+	Total           int
+	TotalLowerBound bool
+	Duration        time.Duration
+	Hits            []*Hit
+}
+
+// ScoreContext 打分需要的集合统计信息与参数
+type ScoreContext struct {
+	TotalDocs int
+	Fields    map[string]*FieldStats
+	BM25      BM25Params
+	BM25F     BM25FParams
+
+	// Params 打分模型的参数, 见 SearchOptions.ScoreParams
+	Params map[string]float64
+
+	// Explain 为 true 时打分函数需要返回得分的解释, 否则返回 nil
+	Explain bool
+}
+
+// avgLen 字段的平均长度, 没有统计信息时返回 0
+func (c *ScoreContext) avgLen(field string) float64 {
+	if fs, ok := c.Fields[field]; ok {
+		return fs.AvgLen()
+	}
+	return 0
 }
 
-type ScoreFunc func(*Hit, []*Token, int) (float64, string)
+type ScoreFunc func(h *Hit, ctx *ScoreContext) (float64, *Explanation)
 
-func tf_idf(h *Hit, tokens []*Token, totalDocs int) (float64, string) {
+func tf_idf(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
 	var score float64
-	explain := ""
+	var explain *Explanation
+	if ctx.Explain {
+		explain = explainf(0, "tf-idf, sum of:")
+	}
 
 	for _, t := range h.hitTokens {
 
-		tf := float64(len(t.pl)) / float64(h.docLen)
+		tf := t.freq() / float64(t.docLen)
 
-		idf := math.Log2(float64(totalDocs) / float64(t.t.DocCount+1))
+		idf := math.Log2(float64(ctx.TotalDocs) / float64(t.t.DocCount+1))
 
 		score += float64(tf) * idf
 
-		explain += fmt.Sprintf("[%s](tf=%v * idf(total/doc=%v)=%v): %v, ", t.t.Value, tf, t.t.DocCount+1, idf, score)
+		if explain != nil {
+			explain.add(explainf(tf*idf, "weight(%s), product of:", t.name()).add(
+				explainf(tf, "tf, freq=%v / dl=%v", t.freq(), t.docLen),
+				explainf(idf, "idf, log2(total=%v / (df=%v + 1))", ctx.TotalDocs, t.t.DocCount),
+			))
+		}
 	}
 
 	//fmt.Printf("tf: %v\n", tf)
 	//	fmt.Printf("log2(%v/%v) %v\n", float64(totalDocs), float64(term.DocCount+1), idf)
+	if explain != nil {
+		explain.Value = score
+	}
 	return score, explain
 }
 
-func lucene_tf_idf(h *Hit, tokens []*Token, totalDocs int) (float64, string) {
+func lucene_tf_idf(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
 	var score float64
-	explain := ""
+	var explain *Explanation
+	if ctx.Explain {
+		explain = explainf(0, "lucene tf-idf, sum of:")
+	}
 
 	for _, t := range h.hitTokens {
 
-		idf := math.Log2(float64(totalDocs) / float64(t.t.DocCount+1))
+		idf := math.Log2(float64(ctx.TotalDocs) / float64(t.t.DocCount+1))
 
-		tf := math.Sqrt(float64(len(t.pl)))
+		tf := math.Sqrt(t.freq())
 
-		fieldNorms := 1 / math.Sqrt(float64(h.docLen))
+		fieldNorms := 1 / math.Sqrt(float64(t.docLen))
 
 		score += tf * idf * fieldNorms
 
-		explain += fmt.Sprintf("[%s](tf=%v * idf(total/doc=%v)=%v norms=%v): %v, ",
-			t.t.Value, tf, t.t.DocCount+1, idf, fieldNorms, score)
+		if explain != nil {
+			explain.add(explainf(tf*idf*fieldNorms, "weight(%s), product of:", t.name()).add(
+				explainf(tf, "tf, sqrt(freq=%v)", t.freq()),
+				explainf(idf, "idf, log2(total=%v / (df=%v + 1))", ctx.TotalDocs, t.t.DocCount),
+				explainf(fieldNorms, "fieldNorm, 1 / sqrt(dl=%v)", t.docLen),
+			))
+		}
 	}
 
 	//fmt.Printf("tf: %v\n", tf)
 	//	fmt.Printf("log2(%v/%v) %v\n", float64(totalDocs), float64(term.DocCount+1), idf)
+	if explain != nil {
+		explain.Value = score
+	}
+	return score, explain
+}
+
+// BM25Params BM25 的参数, Delta > 0 时为 BM25+ (Lv & Zhai, 2011), 避免长文档中的词频得分趋近于 0
+type BM25Params struct {
+	K1    float64
+	B     float64
+	Delta float64 `json:",omitempty"`
+}
+
+var DefaultBM25 = BM25Params{K1: 1.2, B: 0.75}
+
+// bm25 使用字段长度 (位置数) 与字段平均长度做长度归一化:
+//
+//	idf * tf * (k1 + 1) / (tf + k1 * (1 - b + b * dl / avgdl)) + idf * delta
+func bm25(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
+	var score float64
+	var explain *Explanation
+
+	p := ctx.BM25
+	if ctx.Explain {
+		explain = explainf(0, "bm25(k1=%v b=%v delta=%v), sum of:", p.K1, p.B, p.Delta)
+	}
+	for _, t := range h.hitTokens {
+		n := float64(t.t.DocCount)
+		idf := math.Log(1 + (float64(ctx.TotalDocs)-n+0.5)/(n+0.5))
+
+		tf := t.freq()
+		norm := 1.0
+		if avg := ctx.avgLen(t.field); avg > 0 {
+			norm = 1 - p.B + p.B*float64(t.docLen)/avg
+		}
+
+		tfScore := tf * (p.K1 + 1) / (tf + p.K1*norm)
+		score += idf * (tfScore + p.Delta)
+
+		if explain != nil {
+			explain.add(explainf(idf*(tfScore+p.Delta), "weight(%s), idf * (tfNorm + delta) of:", t.name()).add(
+				explainf(idf, "idf, ln(1 + (total=%v - df=%v + 0.5) / (df=%v + 0.5))", ctx.TotalDocs, t.t.DocCount, t.t.DocCount),
+				explainf(tfScore, "tfNorm, freq * (k1 + 1) / (freq + k1 * norm) with freq=%v norm=%v (dl=%v avgdl=%v)",
+					tf, norm, t.docLen, ctx.avgLen(t.field)),
+			))
+		}
+	}
+
+	if explain != nil {
+		explain.Value = score
+	}
 	return score, explain
 }
 
-func bm25(h *Hit, tokens []*Token, totalDocs int) (float64, string) {
+func bm25Plus(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
+	if ctx.BM25.Delta == 0 {
+		c := *ctx
+		c.BM25.Delta = 1
+		ctx = &c
+	}
+	return bm25(h, ctx)
+}
+
+// BM25FParams BM25F 的参数, 未配置的字段权重为 1, b 使用 BM25.B
+type BM25FParams struct {
+	K1     float64
+	Boosts map[string]float64 `json:",omitempty"`
+	B      map[string]float64 `json:",omitempty"`
+}
+
+var DefaultBM25F = BM25FParams{K1: 1.2}
+
+func (c *ScoreContext) boost(field string) float64 {
+	if w, ok := c.BM25F.Boosts[field]; ok {
+		return w
+	}
+	return 1
+}
+
+func (c *ScoreContext) fieldB(field string) float64 {
+	if b, ok := c.BM25F.B[field]; ok {
+		return b
+	}
+	return c.BM25.B
+}
+
+// bm25f 先按字段权重与长度归一化合并各字段的词频, 再做一次词频饱和:
+//
+//	tf' = sum(w_f * tf_f / (1 - b_f + b_f * dl_f / avgdl_f))
+//	idf * tf' * (k1 + 1) / (tf' + k1)
+//
+// 词元按字段区分, idf 使用各字段中最大的文档频率近似
+func bm25f(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
 	var score float64
-	explain := ""
+	var explain *Explanation
+	if ctx.Explain {
+		explain = explainf(0, "bm25f(k1=%v), sum of:", ctx.BM25F.K1)
+	}
+
+	type termStats struct {
+		tf     float64
+		df     int
+		fields []*Explanation
+	}
+	terms := make(map[string]*termStats)
+	var order []string
 
 	for _, t := range h.hitTokens {
+		ts, ok := terms[t.t.Value]
+		if !ok {
+			ts = &termStats{}
+			terms[t.t.Value] = ts
+			order = append(order, t.t.Value)
+		}
+
+		norm := 1.0
+		if avg := ctx.avgLen(t.field); avg > 0 {
+			b := ctx.fieldB(t.field)
+			norm = 1 - b + b*float64(t.docLen)/avg
+		}
 
-		idf := math.Log2(float64(totalDocs) / float64(t.t.DocCount+1))
+		w := ctx.boost(t.field) * t.freq() / norm
+		ts.tf += w
+		if explain != nil {
+			ts.fields = append(ts.fields, explainf(w, "tf of field %s, boost=%v * freq=%v / norm=%v (dl=%v avgdl=%v)",
+				t.field, ctx.boost(t.field), t.freq(), norm, t.docLen, ctx.avgLen(t.field)))
+		}
+		if t.t.DocCount > ts.df {
+			ts.df = t.t.DocCount
+		}
+	}
 
-		// ((k + 1) * tf) / (k + tf)
-		tf := float64(len(t.pl))
-		k := 1.2
-		tfScore := (float64(k+1) * tf) / (k + tf)
+	k1 := ctx.BM25F.K1
+	for _, v := range order {
+		ts := terms[v]
 
-		fieldNorms := 1 / math.Sqrt(float64(len(h.Doc.Fields["Text"])))
+		n := float64(ts.df)
+		idf := math.Log(1 + (float64(ctx.TotalDocs)-n+0.5)/(n+0.5))
 
-		score += tfScore * idf * fieldNorms
+		tfScore := ts.tf * (k1 + 1) / (ts.tf + k1)
+		score += idf * tfScore
 
-		explain += fmt.Sprintf("[%s](tf=%v * idf(total/doc=%v)=%v norms=%v): %v, ",
-			t.t.Value, tfScore, t.t.DocCount+1, idf, fieldNorms, score)
+		if explain != nil {
+			explain.add(explainf(idf*tfScore, "weight(%s), product of:", v).add(
+				explainf(idf, "idf, ln(1 + (total=%v - df=%v + 0.5) / (df=%v + 0.5))", ctx.TotalDocs, ts.df, ts.df),
+				explainf(tfScore, "tfNorm, tf' * (k1 + 1) / (tf' + k1), tf' sum of:").add(
+					explainf(ts.tf, "tf'").add(ts.fields...),
+				),
+			))
+		}
 	}
 
-	//fmt.Printf("tf: %v\n", tf)
-	//	fmt.Printf("log2(%v/%v) %v\n", float64(totalDocs), float64(term.DocCount+1), idf)
+	if explain != nil {
+		explain.Value = score
+	}
 	return score, explain
 }
 
+// ParseQuery 使用索引中的字段名解析查询
+func (s *Searcher) ParseQuery(q string) (Query, error) {
+	return ParseQuery(q, s.ii.Fields())
+}
+
+// SearchOptions 搜索参数
+type SearchOptions struct {
+	// Score 打分模型的名字, 见 RegisterSimilarity. 内置 "tf-idf", "lucene", "bm25", "bm25+", "bm25f",
+	// "dfr", "lm-dirichlet", "lm-jm", 默认为 tf-idf
+	Score string
+	// ScoreParams 打分模型的参数, 如 lm-dirichlet 的 "mu", lm-jm 的 "lambda", dfr 的 "c"
+	ScoreParams map[string]float64
+	// Size 返回的结果数, 为 0 时只统计命中总数
+	Size int
+
+	// From 跳过的结果数. 结果按分数降序, 分数相同时按 docID 升序, 分页结果是稳定的
+	From int
+	// SearchAfter 只返回排在该游标之后的结果, 用于深度翻页
+	SearchAfter *Cursor
+
+	// BM25 / BM25F 覆盖索引的参数
+	BM25  *BM25Params
+	BM25F *BM25FParams
+
+	// ExactTotal 关闭 WAND 剪枝, 统计准确的命中总数
+	ExactTotal bool
+
+	// Highlight 非空时为结果生成高亮片段
+	Highlight *HighlightOptions
+}
+
+// Cursor 结果在排序中的位置, 用于 SearchAfter
+type Cursor struct {
+	Score float64
+	DocID uint64
+}
+
+// Cursor 返回 h 的游标, 作为下一页的 SearchAfter
+func (h *Hit) Cursor() *Cursor {
+	return &Cursor{Score: h.Score, DocID: h.docID}
+}
+
+// String 将游标编码为字符串, 分数按二进制保存, 解码后与原分数完全相同
+func (c *Cursor) String() string {
+	return strconv.FormatUint(math.Float64bits(c.Score), 16) + "-" + strconv.FormatUint(c.DocID, 10)
+}
+
+// ParseCursor 解析 Cursor.String 的结果
+func ParseCursor(s string) (*Cursor, error) {
+	score, docID, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("bad cursor %q", s)
+	}
+
+	bits, err := strconv.ParseUint(score, 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad cursor %q: %v", s, err)
+	}
+	id, err := strconv.ParseUint(docID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad cursor %q: %v", s, err)
+	}
+
+	return &Cursor{Score: math.Float64frombits(bits), DocID: id}, nil
+}
+
+// after h 是否排在 c 之后
+func (c *Cursor) after(h *Hit) bool {
+	return worse(h, &Hit{Score: c.Score, docID: c.DocID})
+}
+
 func (s *Searcher) Search(q string, sf string, n int) *TopHits {
+	hits, err := s.SearchWith(q, &SearchOptions{Score: sf, Size: n})
+	if err != nil {
+		log.Printf("search %q: %v", q, err)
+		return &TopHits{}
+	}
+	return hits
+}
+
+// allFields 索引的全部字段, 不包括拼音子字段 (拼音查询时由 termPostings 加入).
+// 旧版本的索引没有字段信息, 词元的字段为空
+func (s *Searcher) allFields() []string {
+	var fields []string
+	for _, name := range s.ii.Fields() {
+		if !strings.HasSuffix(name, PinyinSuffix) {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) > 0 {
+		return fields
+	}
+	return []string{""}
+}
+
+// scoreContext 打分参数的优先级: 查询参数, 索引 schema 中的参数, 默认参数
+func (s *Searcher) scoreContext(opts *SearchOptions) *ScoreContext {
+	ctx := &ScoreContext{
+		TotalDocs: s.ii.TotalDocs,
+		Fields:    s.ii.fields,
+		BM25:      DefaultBM25,
+		BM25F:     DefaultBM25F,
+		Params:    opts.ScoreParams,
+	}
+
+	if s.spec != nil {
+		if s.spec.BM25 != nil {
+			ctx.BM25 = *s.spec.BM25
+		}
+		if s.spec.BM25F != nil {
+			ctx.BM25F = *s.spec.BM25F
+		}
+	}
+	if opts.BM25 != nil {
+		ctx.BM25 = *opts.BM25
+	}
+	if opts.BM25F != nil {
+		ctx.BM25F = *opts.BM25F
+	}
+
+	return ctx
+}
+
+// SearchWith 解析并执行查询, 查询语法见 Query. opts 为 nil 时使用默认参数
+func (s *Searcher) SearchWith(q string, opts *SearchOptions) (*TopHits, error) {
+	query, err := s.ParseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.SearchQuery(query, opts)
+}
+
+// DefaultSearchSize opts 为 nil 时返回的结果数
+const DefaultSearchSize = 10
+
+// SearchQuery 执行查询, opts 为 nil 时使用默认参数, 返回前 DefaultSearchSize 个结果
+func (s *Searcher) SearchQuery(query Query, opts *SearchOptions) (*TopHits, error) {
 	start := time.Now()
-	terms := s.t.Tokenzie(q, true)
-
-	var hits []*Hit
-
-	// scoreFunc := tf_idf
-	// scoreFunc := lucene_tf_idf
-	var scoreFunc ScoreFunc
-	switch sf {
-	case "bm25":
-		scoreFunc = bm25
-	case "lucene":
-		scoreFunc = lucene_tf_idf
-	default:
-		scoreFunc = tf_idf
-	}
-
-	docs := make(map[uint64]*Hit)
-
-	for _, term := range terms {
-		//	t, ok := s.ii.tokenMap[term.Text]
-		t, err := s.store.GetToken(term.Text)
-		fmt.Printf("token: %v %v %v\n", term.Text, t.ID, err)
-
-		if err == nil {
-			matched := 0
-			err = s.store.ScanPostingListByToken(t.ID, func(pl *PostingList) {
-				//fmt.Printf("\t%v %v --> %v\n", tokenID, docID, posList)
-				matched++
-				h, ok := docs[pl.DocID]
-				if ok {
-					h.hitTokens = append(h.hitTokens, &termHit{t: t, pl: pl.PosList})
-				} else {
-					h = &Hit{
-						docID:     pl.DocID,
-						hitTokens: []*termHit{&termHit{t: t, pl: pl.PosList}},
-						docLen:    pl.DocLen,
-						//Term:      term.Text,
-						// PosList:   posList.PosList,
-					}
-					docs[pl.DocID] = h
-				}
-			})
-
-			fmt.Printf("matched: %v\n", matched)
-			if err != nil {
-				log.Fatal(err)
+	if opts == nil {
+		opts = &SearchOptions{Size: DefaultSearchSize}
+	}
+
+	// 保留前 From + Size 个结果, 最后丢弃前 From 个
+	n := opts.Size
+	if opts.From > 0 && n > 0 {
+		n += opts.From
+	}
+
+	sim, err := s.similarity(opts.Score)
+	if err != nil {
+		return nil, err
+	}
+	scoreFunc := sim.Score
+	allFields := s.allFields()
+	ctx := s.scoreContext(opts)
+
+	var (
+		m    docMatcher
+		wand *wandMatcher
+	)
+	if sim.WAND && !opts.ExactTotal && n > 0 {
+		if wand, err = s.wandMatcher(query, allFields, scoreFunc, ctx); err != nil {
+			return nil, err
+		}
+	}
+	if wand != nil {
+		m = wand
+	} else if m, err = s.matcher(query, allFields); err != nil {
+		return nil, err
+	}
+
+	// 逐个文档打分, 只保留分数最高的 n 个
+	top := &topHits{n: n}
+	total := 0
+	cur := &Hit{}
+	for ; m.docID() != noMoreDocs; m.advance(m.docID() + 1) {
+		total++
+
+		cur.docID = m.docID()
+		cur.hitTokens = m.collect(cur.hitTokens[:0])
+		cur.Score, _ = scoreFunc(cur, ctx)
+		if opts.SearchAfter != nil && !opts.SearchAfter.after(cur) {
+			continue
+		}
+		if top.competitive(cur) {
+			h := *cur
+			h.hitTokens = append([]*termHit(nil), cur.hitTokens...)
+			top.push(&h)
+
+			if wand != nil && len(top.hits) == n {
+				wand.threshold = top.hits[0].Score
 			}
 		}
 	}
+	if err := m.close(); err != nil {
+		return nil, err
+	}
 
-	var err error
-	for _, h := range docs {
-		h.Doc, err = s.store.GetDoc(h.docID)
-		if err == nil {
-			h.Score, h.Explain = scoreFunc(h, nil, s.ii.TotalDocs)
-			hits = append(hits, h)
+	// 只为最终结果加载文档
+	hits := top.sorted()
+	if opts.From >= len(hits) {
+		hits = nil
+	} else if opts.From > 0 {
+		hits = hits[opts.From:]
+	}
+	result := &TopHits{Total: total, TotalLowerBound: wand != nil && wand.skipped}
+	// 只为最终结果生成解释, 与打分使用相同的计算, 得分不变
+	ectx := *ctx
+	ectx.Explain = true
+
+	var hl *HighlightOptions
+	if opts.Highlight != nil {
+		hl = opts.Highlight.withDefaults()
+	}
+	for _, h := range hits {
+		if h.Doc, err = s.store.GetDoc(h.docID); err == nil {
+			_, h.Explain = scoreFunc(h, &ectx)
+			if hl != nil {
+				h.Highlights = s.highlightHit(h, hl)
+			}
+			result.Hits = append(result.Hits, h)
 		}
 	}
 
-	sort.Slice(hits, func(i, j int) bool { return hits[i].Score >= hits[j].Score })
+	result.Duration = time.Now().Sub(start)
+	return result, nil
+}
+
+// topHits 大小为 n 的最小堆, 堆顶是当前结果中最差的一个. 分数相同时 docID 小的优先
+type topHits struct {
+	n    int
+	hits []*Hit
+}
 
-	result := &TopHits{
-		Total:    len(hits),
-		Hits:     hits,
-		Duration: time.Now().Sub(start),
+func worse(a, b *Hit) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
 	}
+	return a.docID > b.docID
+}
+
+func (t *topHits) Len() int           { return len(t.hits) }
+func (t *topHits) Less(i, j int) bool { return worse(t.hits[i], t.hits[j]) }
+func (t *topHits) Swap(i, j int)      { t.hits[i], t.hits[j] = t.hits[j], t.hits[i] }
+func (t *topHits) Push(x any)         { t.hits = append(t.hits, x.(*Hit)) }
+func (t *topHits) Pop() any {
+	h := t.hits[len(t.hits)-1]
+	t.hits = t.hits[:len(t.hits)-1]
+	return h
+}
 
-	if len(hits) > n {
-		result.Hits = hits[:n]
+// competitive h 是否能进入结果
+func (t *topHits) competitive(h *Hit) bool {
+	if t.n <= 0 {
+		return false
+	}
+	return len(t.hits) < t.n || worse(t.hits[0], h)
+}
+
+func (t *topHits) push(h *Hit) {
+	if len(t.hits) < t.n {
+		heap.Push(t, h)
+		return
+	}
+	t.hits[0] = h
+	heap.Fix(t, 0)
+}
+
+// sorted 按分数从高到低返回结果
+func (t *topHits) sorted() []*Hit {
+	hits := make([]*Hit, len(t.hits))
+	for i := len(hits) - 1; i >= 0; i-- {
+		hits[i] = heap.Pop(t).(*Hit)
+	}
+	return hits
+}
+
+// MultiSearcher 同时搜索多个索引, 按分数合并结果, 命中文档的 Doc.Index 标明所属索引
+type MultiSearcher struct {
+	searchers []*Searcher
+}
+
+func NewMultiSearcher(searchers ...*Searcher) *MultiSearcher {
+	return &MultiSearcher{searchers: searchers}
+}
+
+func (m *MultiSearcher) Search(q string, sf string, n int) *TopHits {
+	start := time.Now()
+	result := &TopHits{}
+
+	for _, s := range m.searchers {
+		r := s.Search(q, sf, n)
+		result.Total += r.Total
+		result.TotalLowerBound = result.TotalLowerBound || r.TotalLowerBound
+		result.Hits = append(result.Hits, r.Hits...)
+	}
+
+	// 分数相同时按索引名和 docID 排序, 保证结果稳定
+	sort.Slice(result.Hits, func(i, j int) bool {
+		a, b := result.Hits[i], result.Hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Doc.Index != b.Doc.Index {
+			return a.Doc.Index < b.Doc.Index
+		}
+		return a.docID < b.docID
+	})
+	if len(result.Hits) > n {
+		result.Hits = result.Hits[:n]
 	}
 
+	result.Duration = time.Now().Sub(start)
 	return result
 }
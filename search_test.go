@@ -0,0 +1,293 @@
+package tns
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var testFields = []*FieldSpec{
+	{Name: "Title", Type: FieldText, Indexed: true, Stored: true, Positions: true, Offsets: true},
+	{Name: "Text", Type: FieldText, Indexed: true, Stored: true, Positions: true, Offsets: true},
+}
+
+// testSearcher 将 docs 写入名为 test 的索引, 重新打开后返回它的 Searcher. 文本按空白切分
+func testSearcher(t *testing.T, fields []*FieldSpec, docs ...map[string]string) *Searcher {
+	return testAnalyzerSearcher(t, fields, nil, docs...)
+}
+
+// testAnalyzerSearcher 与 testSearcher 相同, analyzers 同时注册到 Indexer 与 Searcher
+func testAnalyzerSearcher(t *testing.T, fields []*FieldSpec, analyzers map[string]Tokenizer, docs ...map[string]string) *Searcher {
+	path := filepath.Join(t.TempDir(), "test.db")
+	root, err := CreateBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.CreateIndex(&IndexSpec{Name: "test", Fields: fields}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := root.Index("test")
+	ix := NewIndexer(fieldsTokenizer{}, s)
+	for name, a := range analyzers {
+		ix.AddAnalyzer(name, a)
+	}
+	for _, d := range docs {
+		if err := ix.AddDoc(&Document{Fields: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ix.Build().WriteTo(s); err != nil {
+		t.Fatal(err)
+	}
+	root.Close()
+
+	if root, err = CreateBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+
+	s, _ = root.Index("test")
+	ii, err := LoadInvertIndex(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewSearcher(ii, fieldsTokenizer{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, a := range analyzers {
+		sr.AddAnalyzer(name, a)
+	}
+	return sr
+}
+
+func TestSearchNilOptions(t *testing.T) {
+	docs := []map[string]string{
+		{"Title": "a", "Text": "b c"},
+		{"Title": "b", "Text": "c d"},
+		{"Title": "e", "Text": "f"},
+	}
+	for i := 0; i < DefaultSearchSize+2; i++ {
+		docs = append(docs, map[string]string{"Text": "g"})
+	}
+	s := testSearcher(t, testFields, docs...)
+
+	// 默认参数返回前 DefaultSearchSize 个结果
+	hits, err := s.SearchWith("b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits.Total != 2 || len(hits.Hits) != 2 {
+		t.Fatalf("got %d hits, total %d", len(hits.Hits), hits.Total)
+	}
+
+	hits, err = s.SearchWith("g", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits.Hits) != DefaultSearchSize {
+		t.Fatalf("got %d hits, want %d", len(hits.Hits), DefaultSearchSize)
+	}
+}
+
+// searchIDs 返回查询 q 命中的全部文档 ID, 按 ID 升序
+func searchIDs(t *testing.T, s *Searcher, q string) []uint64 {
+	t.Helper()
+	hits, err := s.SearchWith(q, &SearchOptions{Size: 100, ExactTotal: true})
+	if err != nil {
+		t.Fatalf("%q: %v", q, err)
+	}
+
+	ids := []uint64{}
+	for _, h := range hits.Hits {
+		ids = append(ids, h.Doc.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestSearchBoolean(t *testing.T) {
+	s := testSearcher(t, testFields,
+		map[string]string{"Title": "a", "Text": "x y"},
+		map[string]string{"Title": "b", "Text": "x z"},
+		map[string]string{"Title": "x", "Text": "y z"},
+		map[string]string{"Title": "c", "Text": "y"},
+	)
+
+	for q, want := range map[string][]uint64{
+		"x AND y":                    {1, 3},
+		"x OR z":                     {1, 2, 3},
+		"x z":                        {1, 2, 3},
+		"+y x":                       {1, 3, 4},
+		"x -z":                       {1},
+		"x AND NOT z":                {1},
+		"y AND (a OR c)":             {1, 4},
+		"(x AND z) OR (a AND y)":     {1, 2, 3},
+		"(x OR c) AND NOT (z AND b)": {1, 3, 4},
+		"Title:x AND y":              {3},
+		"Text:x AND y":               {1},
+		"Title:(a OR b) AND Text:z":  {2},
+		"x AND y AND z":              {3},
+		// 只有 must_not 子句时没有可以匹配的文档
+		"-x":                 {},
+		"-x -y":              {},
+		"w OR (a AND NOT z)": {1},
+	} {
+		if ids := searchIDs(t, s, q); !reflect.DeepEqual(ids, want) {
+			t.Fatalf("%q: got %v, want %v", q, ids, want)
+		}
+	}
+}
+
+func TestSearchPhrase(t *testing.T) {
+	s := testSearcher(t, testFields,
+		map[string]string{"Text": "a b c d e"},
+		map[string]string{"Text": "b a c d e"},
+		map[string]string{"Text": "a x b c b"},
+		map[string]string{"Text": "a x y b c"},
+		map[string]string{"Text": "a x y z b"},
+	)
+
+	// 调换顺序的偏差为 2
+	for q, want := range map[string][]uint64{
+		`"a b"`:   {1},
+		`"b a"`:   {2},
+		`"a b"~1`: {1, 3},
+		`"a b"~2`: {1, 2, 3, 4},
+		`"a b"~3`: {1, 2, 3, 4, 5},
+		`"a d"`:   {},
+	} {
+		if ids := searchIDs(t, s, q); !reflect.DeepEqual(ids, want) {
+			t.Fatalf("%s: got %v, want %v", q, ids, want)
+		}
+	}
+
+	// 词元越接近得分越高
+	hits, err := s.SearchWith(`"a b"~3`, &SearchOptions{Size: 5, Score: "bm25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := make(map[uint64]float64)
+	for _, h := range hits.Hits {
+		scores[h.Doc.ID] = h.Score
+	}
+	if !(scores[1] > scores[3] && scores[3] > scores[4] && scores[4] > scores[5]) {
+		t.Fatalf("scores %v", scores)
+	}
+
+	// 高亮使用短语匹配到的位置, 窗口外的 b 不高亮
+	hits, err = s.SearchWith(`"a b"~1`, &SearchOptions{Size: 5, Highlight: &HighlightOptions{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hits.Hits {
+		want := map[uint64]string{1: "<em>a</em> <em>b</em> c d e", 3: "<em>a</em> x <em>b</em> c b"}[h.Doc.ID]
+		if got := h.Highlights["Text"]; len(got) != 1 || got[0] != want {
+			t.Fatalf("doc %d: highlights %q, want %q", h.Doc.ID, got, want)
+		}
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	var docs []map[string]string
+	for i := 0; i < 20; i++ {
+		// 每 4 篇文档的内容相同, 分数相同
+		docs = append(docs, map[string]string{"Text": strings.Repeat("a ", 1+i%4) + "b"})
+	}
+	s := testSearcher(t, testFields, docs...)
+
+	all, err := s.SearchWith("a", &SearchOptions{Size: len(docs)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Hits) != len(docs) {
+		t.Fatalf("got %d hits", len(all.Hits))
+	}
+	for i := 1; i < len(all.Hits); i++ {
+		prev, h := all.Hits[i-1], all.Hits[i]
+		if prev.Score < h.Score || (prev.Score == h.Score && prev.Doc.ID >= h.Doc.ID) {
+			t.Fatalf("hit %d: doc %d (%v) after doc %d (%v)", i, h.Doc.ID, h.Score, prev.Doc.ID, prev.Score)
+		}
+	}
+
+	const size = 3
+	var after *Cursor
+	for from := 0; from < len(docs); from += size {
+		page, err := s.SearchWith("a", &SearchOptions{Size: size, From: from})
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := s.SearchWith("a", &SearchOptions{Size: size, SearchAfter: after})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		end := from + size
+		if end > len(all.Hits) {
+			end = len(all.Hits)
+		}
+		want := all.Hits[from:end]
+		for _, got := range [][]*Hit{page.Hits, next.Hits} {
+			if len(got) != len(want) {
+				t.Fatalf("page at %d: got %d hits, want %d", from, len(got), len(want))
+			}
+			for i := range got {
+				if got[i].Doc.ID != want[i].Doc.ID {
+					t.Fatalf("page at %d, hit %d: doc %d, want %d", from, i, got[i].Doc.ID, want[i].Doc.ID)
+				}
+			}
+		}
+
+		// 游标经过字符串编码后传给下一页
+		if after, err = ParseCursor(next.Hits[len(next.Hits)-1].Cursor().String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err := s.SearchWith("a", &SearchOptions{Size: size, SearchAfter: after})
+	if err != nil || len(last.Hits) != 0 {
+		t.Fatalf("after last page: %v hits, %v", last, err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	for _, c := range []Cursor{{0, 0}, {1.5, 7}, {-2.25, 1 << 40}, {math.SmallestNonzeroFloat64, 3}, {math.Inf(1), math.MaxUint64}} {
+		got, err := ParseCursor(c.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != c {
+			t.Fatalf("got %+v, want %+v", got, c)
+		}
+	}
+
+	for _, s := range []string{"", "1", "-", "3ff8000000000000-", "-7", "xyz-7", "3ff8000000000000-x", "3ff8000000000000-7-1", "3ff8000000000000--7"} {
+		if c, err := ParseCursor(s); err == nil {
+			t.Fatalf("ParseCursor(%q) = %+v", s, c)
+		}
+	}
+}
+
+func TestSearchPinyin(t *testing.T) {
+	fields := []*FieldSpec{{Name: "Title", Type: FieldText, Indexed: true, Stored: true, Pinyin: true}}
+	s := testSearcher(t, fields,
+		map[string]string{"Title": "中国 银行"},
+		map[string]string{"Title": "重庆 火锅"},
+		map[string]string{"Title": "深圳 湾"},
+		map[string]string{"Title": "重要 通知"},
+	)
+
+	for q, want := range map[string]uint64{"yinhang": 1, "chongqing": 2, "shenzhen": 3, "zhongyao": 4, "ChongQing": 2, "cq": 2} {
+		hits, err := s.SearchWith(q, &SearchOptions{Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits.Hits) != 1 || hits.Hits[0].Doc.ID != want {
+			t.Fatalf("%q: got %d hits, want doc %d", q, len(hits.Hits), want)
+		}
+	}
+}
@@ -5,9 +5,21 @@ import (
 	"github.com/yanyiwu/gojieba"
 )
 
+// Term 分词结果, Start / End 为词元在原文中的字节偏移 [Start, End).
+// Pos 为词元的位置, 由 Analyzer 设置, 分词器不需要设置 (MultiTokenizer 除外)
 type Term struct {
 	Text  string
 	Start int
+	End   int
+	Pos   int
+}
+
+// end 兼容没有设置 End 的分词器
+func (t *Term) end() int {
+	if t.End > t.Start {
+		return t.End
+	}
+	return t.Start + len(t.Text)
 }
 
 type Tokenizer interface {
@@ -22,9 +34,10 @@ func NewSegoTokenizer(dictPath string) Tokenizer {
 	}
 }
 
-func NewJiebaTokenizer(dictPath ...string) Tokenizer {
+// NewJiebaTokenizer 全文模式的 jieba 分词器, 保留所有词元. 只索引关键词时在 Analyzer 中加入 KeywordFilter
+func NewJiebaTokenizer(dictPath ...string) *JiebaTokenizer {
 	j := gojieba.NewJieba(dictPath...)
-	return &jiebaTokenizer{
+	return &JiebaTokenizer{
 		j: j,
 	}
 }
@@ -39,15 +52,16 @@ func (t *segoTokenizer) Tokenzie(text string, searchMode bool) []Term {
 	for i, seg := range s {
 		terms[i].Text = seg.Token().Text()
 		terms[i].Start = seg.Start()
+		terms[i].End = seg.End()
 	}
 	return terms
 }
 
-type jiebaTokenizer struct {
+type JiebaTokenizer struct {
 	j *gojieba.Jieba
 }
 
-func (t *jiebaTokenizer) Tokenzie(text string, searchMode bool) []Term {
+func (t *JiebaTokenizer) Tokenzie(text string, searchMode bool) []Term {
 	m := gojieba.DefaultMode
 	if searchMode {
 		m = gojieba.SearchMode
@@ -55,20 +69,15 @@ func (t *jiebaTokenizer) Tokenzie(text string, searchMode bool) []Term {
 
 	words := t.j.Tokenize(text, m, false)
 
-	keywords := t.j.Extract(text, 100)
-	kwm := make(map[string]bool)
-	for _, w := range keywords {
-		kwm[w] = true
-	}
-
-	var terms []Term
-	for _, w := range words {
-		if _, ok := kwm[w.Str]; !ok {
-			continue
-		}
-
-		terms = append(terms, Term{w.Str, w.Start})
+	terms := make([]Term, len(words))
+	for i, w := range words {
+		terms[i] = Term{Text: w.Str, Start: w.Start, End: w.End}
 	}
 
 	return terms
 }
+
+// KeywordFilter 返回使用同一个 jieba 实例按 TF-IDF 提取关键词的 KeywordFilter
+func (t *JiebaTokenizer) KeywordFilter(topK int) *KeywordFilter {
+	return &KeywordFilter{TopK: topK, Extract: t.j.Extract}
+}
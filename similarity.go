@@ -0,0 +1,267 @@
+package tns
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Stat 打分模型依赖的索引统计信息
+type Stat uint
+
+const (
+	// StatDocFreq 词元的文档频率 Token.DocCount 与文档总数
+	StatDocFreq Stat = 1 << iota
+	// StatCollectionFreq 词元在所有文档中的出现次数 Token.PosCount
+	StatCollectionFreq
+	// StatFieldLength 字段统计信息 FieldStats (字段总长度, 平均长度), 旧版本的索引没有
+	StatFieldLength
+)
+
+// Similarity 注册的打分模型
+type Similarity struct {
+	Name  string
+	Score ScoreFunc
+	// Stats 打分需要的索引统计信息, 索引缺少时搜索返回 ErrMissingStats
+	Stats Stat
+	// WAND 打分是否满足 WAND 剪枝的条件: 单个词元的得分随词频递增, 随字段长度递减,
+	// 且多个词元的得分不超过各自得分之和. 为 false 时不做剪枝
+	WAND bool
+}
+
+var (
+	ErrUnknownSimilarity = errors.New("unknown similarity")
+	ErrMissingStats      = errors.New("index missing statistics required by similarity")
+)
+
+var (
+	similarityMu sync.RWMutex
+	similarities = make(map[string]*Similarity)
+)
+
+func init() {
+	for _, sim := range []*Similarity{
+		{Name: "tf-idf", Score: tf_idf, Stats: StatDocFreq, WAND: true},
+		{Name: "lucene", Score: lucene_tf_idf, Stats: StatDocFreq, WAND: true},
+		{Name: "bm25", Score: bm25, Stats: StatDocFreq, WAND: true},
+		{Name: "bm25+", Score: bm25Plus, Stats: StatDocFreq, WAND: true},
+		{Name: "bm25f", Score: bm25f, Stats: StatDocFreq, WAND: true},
+		{Name: "dfr", Score: dfr, Stats: StatDocFreq, WAND: true},
+		{Name: "lm-dirichlet", Score: lmDirichlet, Stats: StatCollectionFreq | StatFieldLength, WAND: true},
+		{Name: "lm-jm", Score: lmJelinekMercer, Stats: StatCollectionFreq | StatFieldLength, WAND: true},
+	} {
+		if err := RegisterSimilarity(sim); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterSimilarity 注册打分模型, 之后可以通过 SearchOptions.Score 按名字使用. 名字不能重复
+func RegisterSimilarity(sim *Similarity) error {
+	if sim.Name == "" || sim.Score == nil {
+		return fmt.Errorf("similarity needs a name and a score func")
+	}
+
+	similarityMu.Lock()
+	defer similarityMu.Unlock()
+
+	if _, ok := similarities[sim.Name]; ok {
+		return fmt.Errorf("similarity %q already registered", sim.Name)
+	}
+	similarities[sim.Name] = sim
+	return nil
+}
+
+// GetSimilarity 按名字查找打分模型, 名字为空时返回 tf-idf
+func GetSimilarity(name string) (*Similarity, error) {
+	if name == "" {
+		name = "tf-idf"
+	}
+
+	similarityMu.RLock()
+	defer similarityMu.RUnlock()
+
+	sim, ok := similarities[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSimilarity, name)
+	}
+	return sim, nil
+}
+
+// Similarities 已注册的打分模型名字
+func Similarities() []string {
+	similarityMu.RLock()
+	defer similarityMu.RUnlock()
+
+	names := make([]string, 0, len(similarities))
+	for name := range similarities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// similarity 查找打分模型并检查索引是否有它需要的统计信息
+func (s *Searcher) similarity(name string) (*Similarity, error) {
+	sim, err := GetSimilarity(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if missing := sim.Stats &^ s.ii.stats(); missing != 0 {
+		return nil, fmt.Errorf("%w: %s needs %b", ErrMissingStats, sim.Name, missing)
+	}
+	return sim, nil
+}
+
+// TermMatch 文档中命中的一个词元及其统计信息, 供自定义打分函数使用
+type TermMatch struct {
+	Field  string
+	Value  string
+	Freq   float64 // 词频, 短语为按位置偏差加权后的词频
+	DocLen int     // 字段长度 (位置数)
+
+	DocFreq  int // 包含该词元的文档数
+	CollFreq int // 该词元在所有文档中出现的次数
+}
+
+// Matches 返回文档中命中的词元
+func (h *Hit) Matches() []TermMatch {
+	ms := make([]TermMatch, len(h.hitTokens))
+	for i, th := range h.hitTokens {
+		ms[i] = TermMatch{
+			Field:    th.field,
+			Value:    th.t.Value,
+			Freq:     th.freq(),
+			DocLen:   th.docLen,
+			DocFreq:  th.t.DocCount,
+			CollFreq: th.t.PosCount,
+		}
+	}
+	return ms
+}
+
+// Param 返回打分参数 name, 没有设置时返回 def
+func (c *ScoreContext) Param(name string, def float64) float64 {
+	if v, ok := c.Params[name]; ok {
+		return v
+	}
+	return def
+}
+
+// collectionProb 词元在字段所有文本中出现的概率 p(t|C), 加一平滑
+func (c *ScoreContext) collectionProb(th *termHit) float64 {
+	var total int64
+	if fs, ok := c.Fields[th.field]; ok {
+		total = fs.TotalLen
+	}
+	return float64(th.t.PosCount+1) / float64(total+1)
+}
+
+// dfr 随机性偏离 (Divergence From Randomness, Amati & van Rijsbergen, 2002) 中的 I(n)L2 模型:
+//
+//	tfn = tf * log2(1 + c * avgdl / dl)
+//	tfn / (tfn + 1) * log2((N + 1) / (df + 0.5))
+//
+// 参数 "c" 默认为 1
+func dfr(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
+	var score float64
+	var explain *Explanation
+
+	c := ctx.Param("c", 1)
+	if ctx.Explain {
+		explain = explainf(0, "dfr I(n)L2(c=%v), sum of:", c)
+	}
+
+	for _, t := range h.hitTokens {
+		tfn := t.freq()
+		if avg := ctx.avgLen(t.field); avg > 0 {
+			tfn *= math.Log2(1 + c*avg/float64(t.docLen))
+		}
+
+		inf := math.Log2((float64(ctx.TotalDocs) + 1) / (float64(t.t.DocCount) + 0.5))
+		gain := tfn / (tfn + 1)
+		score += gain * inf
+
+		if explain != nil {
+			explain.add(explainf(gain*inf, "weight(%s), product of:", t.name()).add(
+				explainf(inf, "I(n), log2((total=%v + 1) / (df=%v + 0.5))", ctx.TotalDocs, t.t.DocCount),
+				explainf(gain, "L2, tfn / (tfn + 1) with tfn=%v (freq=%v dl=%v avgdl=%v)",
+					tfn, t.freq(), t.docLen, ctx.avgLen(t.field)),
+			))
+		}
+	}
+
+	if explain != nil {
+		explain.Value = score
+	}
+	return score, explain
+}
+
+// lmDirichlet 使用 Dirichlet 平滑的查询似然语言模型 (Zhai & Lafferty, 2001):
+//
+//	log(1 + tf / (mu * p(t|C))) + log(mu / (dl + mu))
+//
+// 与 Lucene 一致, 每个词元的得分不小于 0. 参数 "mu" 默认为 2000
+func lmDirichlet(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
+	var score float64
+	var explain *Explanation
+
+	mu := ctx.Param("mu", 2000)
+	if ctx.Explain {
+		explain = explainf(0, "lm dirichlet(mu=%v), sum of:", mu)
+	}
+
+	for _, t := range h.hitTokens {
+		p := ctx.collectionProb(t)
+		tfScore := math.Log(1 + t.freq()/(mu*p))
+		lenScore := math.Log(mu / (float64(t.docLen) + mu))
+		w := math.Max(tfScore+lenScore, 0)
+		score += w
+
+		if explain != nil {
+			explain.add(explainf(w, "weight(%s), max(0, sum of):", t.name()).add(
+				explainf(tfScore, "log(1 + freq=%v / (mu * p(t|C)=%v)), cf=%v", t.freq(), p, t.t.PosCount),
+				explainf(lenScore, "log(mu / (dl=%v + mu))", t.docLen),
+			))
+		}
+	}
+
+	if explain != nil {
+		explain.Value = score
+	}
+	return score, explain
+}
+
+// lmJelinekMercer 使用 Jelinek-Mercer 平滑的查询似然语言模型:
+//
+//	log(1 + (1 - lambda) * tf / dl / (lambda * p(t|C)))
+//
+// 参数 "lambda" 默认为 0.1, 适合短查询
+func lmJelinekMercer(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
+	var score float64
+	var explain *Explanation
+
+	lambda := ctx.Param("lambda", 0.1)
+	if ctx.Explain {
+		explain = explainf(0, "lm jelinek-mercer(lambda=%v), sum of:", lambda)
+	}
+
+	for _, t := range h.hitTokens {
+		p := ctx.collectionProb(t)
+		w := math.Log(1 + (1-lambda)*t.freq()/float64(t.docLen)/(lambda*p))
+		score += w
+
+		if explain != nil {
+			explain.add(explainf(w, "weight(%s), log(1 + (1 - lambda) * freq=%v / dl=%v / (lambda * p(t|C)=%v)), cf=%v",
+				t.name(), t.freq(), t.docLen, p, t.t.PosCount))
+		}
+	}
+
+	if explain != nil {
+		explain.Value = score
+	}
+	return score, explain
+}
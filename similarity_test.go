@@ -0,0 +1,181 @@
+package tns
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSimilarityRegistry(t *testing.T) {
+	sim, err := GetSimilarity("")
+	if err != nil || sim.Name != "tf-idf" {
+		t.Fatalf("default similarity %v %v", sim, err)
+	}
+
+	if err := RegisterSimilarity(&Similarity{Name: "bm25", Score: bm25}); err == nil {
+		t.Fatal("duplicate name registered")
+	}
+	if _, err := GetSimilarity("nope"); !errors.Is(err, ErrUnknownSimilarity) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestLMDirichlet(t *testing.T) {
+	ctx := &ScoreContext{
+		TotalDocs: 10,
+		Fields:    map[string]*FieldStats{"Text": {Name: "Text", DocCount: 10, TotalLen: 999}},
+		Params:    map[string]float64{"mu": 100},
+	}
+	h := &Hit{hitTokens: []*termHit{{t: &Token{Value: "a", DocCount: 5, PosCount: 9}, pl: []int{1, 2}, field: "Text", docLen: 100}}}
+
+	// p(t|C) = (9 + 1) / (999 + 1) = 0.01
+	want := math.Log(1+2/(100*0.01)) + math.Log(100.0/200)
+	if got, _ := lmDirichlet(h, ctx); math.Abs(got-want) > 1e-12 {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLMJelinekMercer(t *testing.T) {
+	ctx := &ScoreContext{
+		TotalDocs: 10,
+		Fields:    map[string]*FieldStats{"Text": {Name: "Text", DocCount: 10, TotalLen: 999}},
+	}
+	h := &Hit{hitTokens: []*termHit{{t: &Token{Value: "a", DocCount: 5, PosCount: 9}, pl: []int{1, 2}, field: "Text", docLen: 100}}}
+
+	// p(t|C) = 0.01, tf / dl = 0.02
+	//	lambda = 0.1: log(1 + 0.9 * 0.02 / 0.001) = log(19)
+	//	lambda = 0.5: log(1 + 0.5 * 0.02 / 0.005) = log(3)
+	for lambda, want := range map[float64]float64{0: math.Log(19), 0.5: math.Log(3)} {
+		ctx.Params = nil
+		if lambda > 0 {
+			ctx.Params = map[string]float64{"lambda": lambda}
+		}
+		if got, _ := lmJelinekMercer(h, ctx); math.Abs(got-want) > 1e-12 {
+			t.Fatalf("lambda %v: got %v, want %v", lambda, got, want)
+		}
+	}
+}
+
+func TestDFR(t *testing.T) {
+	// a: df=3 freq=2, b: df=1 freq=1, dl=20, avgdl=10, N=10
+	//	I(n): log2(11 / 3.5) = 1.65208, log2(11 / 1.5) = 2.87447
+	//	c = 1: tfn(a) = 2 * log2(1.5) = 1.16993, tfn(b) = log2(1.5) = 0.58496
+	//	c = 2: tfn(a) = 2 * log2(2) = 2, tfn(b) = 1
+	h := &Hit{hitTokens: []*termHit{
+		{t: &Token{Value: "a", DocCount: 3}, pl: []int{1, 5}, field: "Text", docLen: 20},
+		{t: &Token{Value: "b", DocCount: 1}, pl: []int{3}, field: "Text", docLen: 20},
+	}}
+
+	for c, want := range map[float64]float64{
+		1: 1.9516057421511626,
+		2: 2.0/3*math.Log2(11/3.5) + 0.5*math.Log2(11/1.5),
+	} {
+		ctx := bm25TestContext()
+		ctx.Explain = true
+		if c != 1 {
+			ctx.Params = map[string]float64{"c": c}
+		}
+		got, explain := dfr(h, ctx)
+		if math.Abs(got-want) > 1e-9 || explain.Value != got {
+			t.Fatalf("c %v: got %v (explain %v), want %v", c, got, explain.Value, want)
+		}
+	}
+}
+
+func TestCustomSimilarity(t *testing.T) {
+	// 得分为命中词元的词频之和
+	if _, err := GetSimilarity("test-freq"); err != nil {
+		err := RegisterSimilarity(&Similarity{Name: "test-freq", Score: func(h *Hit, ctx *ScoreContext) (float64, *Explanation) {
+			var score float64
+			for _, m := range h.Matches() {
+				score += m.Freq
+			}
+			return score, nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := testSearcher(t, testFields,
+		map[string]string{"Text": "a b"},
+		map[string]string{"Text": "a a a"},
+		map[string]string{"Text": "a a b c d e f"},
+	)
+	hits, err := s.SearchWith("a", &SearchOptions{Size: 10, Score: "test-freq"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		id    uint64
+		score float64
+	}{{2, 3}, {3, 2}, {1, 1}}
+	if len(hits.Hits) != len(want) {
+		t.Fatalf("got %d hits", len(hits.Hits))
+	}
+	for i, h := range hits.Hits {
+		if h.Doc.ID != want[i].id || h.Score != want[i].score {
+			t.Fatalf("hit %d: doc %d score %v, want doc %d score %v", i, h.Doc.ID, h.Score, want[i].id, want[i].score)
+		}
+	}
+}
+
+// bm25TestContext 10 篇文档, Title 平均长度 2, Text 平均长度 10
+func bm25TestContext() *ScoreContext {
+	return &ScoreContext{
+		TotalDocs: 10,
+		Fields: map[string]*FieldStats{
+			"Title": {Name: "Title", DocCount: 10, TotalLen: 20},
+			"Text":  {Name: "Text", DocCount: 10, TotalLen: 100},
+		},
+		BM25:  DefaultBM25,
+		BM25F: DefaultBM25F,
+	}
+}
+
+func TestBM25(t *testing.T) {
+	// a: df=3 freq=2, b: df=1 freq=1, dl=20
+	//	idf(a) = ln(1 + 7.5 / 3.5) = 1.14513, idf(b) = ln(1 + 9.5 / 1.5) = 1.99243
+	//	norm = 1 - 0.75 + 0.75 * 20 / 10 = 1.75
+	//	tfNorm(a) = 2 * 2.2 / (2 + 1.2 * 1.75) = 1.07317, tfNorm(b) = 2.2 / (1 + 1.2 * 1.75) = 0.70968
+	h := &Hit{hitTokens: []*termHit{
+		{t: &Token{Value: "a", DocCount: 3}, pl: []int{1, 5}, field: "Text", docLen: 20},
+		{t: &Token{Value: "b", DocCount: 1}, pl: []int{3}, field: "Text", docLen: 20},
+	}}
+
+	for _, c := range []struct {
+		score ScoreFunc
+		want  float64
+	}{
+		{bm25, 2.6429051704326216},
+		// 每个词元加上 idf * delta, delta = 1
+		{bm25Plus, 5.78046763942583},
+	} {
+		ctx := bm25TestContext()
+		ctx.Explain = true
+		got, explain := c.score(h, ctx)
+		if math.Abs(got-c.want) > 1e-9 || explain.Value != got {
+			t.Fatalf("got %v (explain %v), want %v", got, explain.Value, c.want)
+		}
+	}
+}
+
+func TestBM25F(t *testing.T) {
+	ctx := bm25TestContext()
+	ctx.BM25F = BM25FParams{K1: 1.2, Boosts: map[string]float64{"Title": 2}, B: map[string]float64{"Title": 0.5}}
+
+	// Title: boost=2 freq=1 dl=4, norm = 1 - 0.5 + 0.5 * 4 / 2 = 1.5
+	// Text: boost=1 freq=2 dl=20, norm = 1 - 0.75 + 0.75 * 20 / 10 = 1.75
+	//	tf' = 2 / 1.5 + 2 / 1.75 = 2.47619
+	//	idf 使用最大的 df=3: ln(1 + 7.5 / 3.5) = 1.14513
+	//	score = 1.14513 * 2.47619 * 2.2 / (2.47619 + 1.2) = 1.69693
+	h := &Hit{hitTokens: []*termHit{
+		{t: &Token{Value: "a", DocCount: 2}, pl: []int{0}, field: "Title", docLen: 4},
+		{t: &Token{Value: "a", DocCount: 3}, pl: []int{1, 5}, field: "Text", docLen: 20},
+	}}
+
+	if got, _ := bm25f(h, ctx); math.Abs(got-1.6969318084490093) > 1e-9 {
+		t.Fatalf("got %v", got)
+	}
+}
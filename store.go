@@ -5,25 +5,67 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 type Store interface {
+	// CreateIndex 创建一个命名索引, 每个索引有独立的文档, 词元与倒排表
+	CreateIndex(spec *IndexSpec) error
+	ListIndexes() ([]*IndexSpec, error)
+	// DropIndex 删除索引及其全部数据
+	DropIndex(name string) error
+	// Index 返回名为 name 的索引的 Store, 之后的读写都限定在该索引内
+	Index(name string) (Store, error)
+	// IndexName 返回当前 Store 对应的索引名, 默认索引为空串
+	IndexName() string
+
+	PutIndexSpec(spec *IndexSpec) error
+	GetIndexSpec(name string) (*IndexSpec, error)
+
 	AddDoc(doc *Document) error
 	GetDoc(id uint64) (*Document, error)
-	DelDoc(id uint64) error
+	// delDoc 只记录 tombstone, 不修正词元与字段统计, 删除文档需要使用 Indexer.DelDoc
+	delDoc(id uint64) error
 	DocCount() (int, error)
 
-	AllocToken(token string) (tk *Token, err error)
-	GetToken(token string) (*Token, error)
+	// upsertDoc 以调用方提供的外部 key 写入文档: 分配新的 ID 并立即保存,
+	// 若 key 已对应旧文档则在同一事务中删除旧文档, 返回旧文档 ID (不存在时为 0).
+	// 与 delDoc 一样不修正统计信息, 需要使用 Indexer.UpsertDoc
+	upsertDoc(key string, doc *Document) (oldID uint64, err error)
+	// LookupDoc 返回外部 key 对应的文档 ID
+	LookupDoc(key string) (uint64, error)
+
+	// putDocTerms 保存文档中未保存 (Stored=false) 字段的词频, 删除文档时由 docTerms 读出以修正统计信息.
+	// delDoc 与 upsertDoc 会一并删除旧文档的记录
+	putDocTerms(id uint64, fts []*fieldTerms) error
+	docTerms(id uint64) ([]*fieldTerms, error)
+
+	AllocToken(field, token string) (tk *Token, err error)
+	GetToken(field, token string) (*Token, error)
+	LookupToken(field, token string) (*Token, error)
 	UpdateToken(token *Token) error
 
+	GetFields() ([]*FieldStats, error)
+	UpdateField(fs *FieldStats) error
+
 	AddPostingList(pl *PostingList) error
 
+	// AddSegment 将一批倒排表写成一个不可变的段文件
+	AddSegment(iiMap map[uint64]map[uint64]*PostingList) error
+	// MergeSegments 按合并策略合并段文件, 直到没有需要合并的段
+	MergeSegments(p *MergePolicy) error
+
 	ScanToken(f func(token *Token)) error
 
 	ScanPostingListByToken(tokenID uint64, f func(pl *PostingList)) error
+	PostingListIterator(tokenID uint64) (PostingIterator, error)
 	ScanPostingList(f func(pl *PostingList)) error
 
 	Close() error
@@ -32,6 +74,28 @@ type Store interface {
 type BoltStore struct {
 	db *bolt.DB
 
+	// name 索引名, 默认索引 (name 为空) 使用顶层 bucket, 命名索引的 bucket 位于 indexes/<name> 下
+	name string
+	root *BoltStore
+
+	// indexes 已打开的命名索引, 只在 root 上使用
+	indexMu sync.Mutex
+	indexes map[string]*BoltStore
+	// dropped 索引已被 DropIndex 删除, 之后的操作返回 ErrIndexNotFound
+	dropped int32
+	// closed 已调用 Close, 之后的操作返回 ErrIndexClosed. Index 返回的 Store 被多处共享,
+	// 关闭后其他持有者需要重新调用 Index
+	closed int32
+
+	// mu 保护 segments 和 deleted, 扫描期间持有读锁, 合并完成替换段时持有写锁
+	mu         sync.RWMutex
+	mergeMu    sync.Mutex
+	segmentDir string
+	segments   []*storeSegment
+
+	// deleted 已删除文档的集合 (tombstone), 扫描时跳过, 合并段时清除
+	deleted map[uint64]bool
+
 	docPending   []*Document
 	tokenPending []*Token
 	plPending    []*PostingList
@@ -42,9 +106,62 @@ var (
 	tokenBucket = []byte("token")
 	iiBucket    = []byte("ii")
 
+	// segment 记录当前有效的段文件: seq -> 文件名
+	segmentBucket = []byte("segment")
+
+	// deleted 记录已删除的文档 ID, 合并段时据此丢弃对应的 posting
+	deletedBucket = []byte("deleted")
+
+	// key 记录外部 key 到文档 ID 的映射
+	docKeyBucket = []byte("key")
+
+	// keyid 记录文档 ID 到外部 key 的映射, 删除文档时据此删除 key
+	docIDKeyBucket = []byte("keyid")
+
+	// terms 记录文档中未保存字段的词频: 文档 ID -> []fieldTerms
+	docTermsBucket = []byte("terms")
+
+	// field 记录字段统计信息: 字段名 -> FieldStats
+	fieldBucket = []byte("field")
+
+	// schema 记录索引定义: 索引名 -> IndexSpec
+	schemaBucket = []byte("schema")
+
+	// indexes 下每个命名索引一个 bucket, 其中包含 doc, token, ii 等 bucket
+	indexesBucket = []byte("indexes")
+
+	indexNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 	flushTreshold = 4096
 )
 
+type storeSegment struct {
+	seq uint64
+	*InvertFile
+
+	// refs 引用计数, store 自身持有一个引用, 迭代器各持有一个引用.
+	// 合并后的旧段标记为 obsolete, 最后一个引用释放时关闭并删除
+	refs     int32
+	obsolete bool
+}
+
+func newStoreSegment(seq uint64, f *InvertFile) *storeSegment {
+	return &storeSegment{seq: seq, InvertFile: f, refs: 1}
+}
+
+func (seg *storeSegment) acquire() {
+	atomic.AddInt32(&seg.refs, 1)
+}
+
+func (seg *storeSegment) release() {
+	if atomic.AddInt32(&seg.refs, -1) == 0 {
+		seg.Close()
+		if seg.obsolete {
+			os.Remove(seg.Path())
+		}
+	}
+}
+
 func CreateBoltStore(path string) (Store, error) {
 	db, err := bolt.Open(path, 0666, nil)
 	if err != nil {
@@ -55,21 +172,307 @@ func CreateBoltStore(path string) (Store, error) {
 	return NewBoltStore(db)
 }
 
+var dataBuckets = [][]byte{docBucket, tokenBucket, iiBucket, segmentBucket, deletedBucket, docKeyBucket, docIDKeyBucket, docTermsBucket, fieldBucket}
+
 func NewBoltStore(db *bolt.DB) (Store, error) {
 	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(docBucket)
-		_, err = tx.CreateBucketIfNotExists(tokenBucket)
-		_, err = tx.CreateBucketIfNotExists(iiBucket)
+		_, err := tx.CreateBucketIfNotExists(schemaBucket)
+		_, err = tx.CreateBucketIfNotExists(indexesBucket)
+		for _, name := range dataBuckets {
+			_, err = tx.CreateBucketIfNotExists(name)
+		}
+		return err
+	})
+
+	s := &BoltStore{
+		db:         db,
+		segmentDir: db.Path() + ".seg",
+		indexes:    make(map[string]*BoltStore),
+	}
+	s.root = s
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *BoltStore) open() error {
+	s.deleted = make(map[uint64]bool)
+
+	// 旧版本创建的索引可能缺少部分 bucket
+	if s.name != "" {
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(indexesBucket).Bucket([]byte(s.name))
+			for _, name := range dataBuckets {
+				if _, err := b.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.loadSegments(); err != nil {
+		return err
+	}
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		return s.bucket(tx, deletedBucket).ForEach(func(k, v []byte) error {
+			s.deleted[btoi(k)] = true
+			return nil
+		})
+	})
+}
+
+// bucket 返回当前索引下名为 name 的 bucket
+func (s *BoltStore) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	if s.name == "" {
+		return tx.Bucket(name)
+	}
+
+	b := tx.Bucket(indexesBucket).Bucket([]byte(s.name))
+	if b == nil {
+		return nil
+	}
+	return b.Bucket(name)
+}
+
+// view / update 在事务中执行 fn, 索引已被删除时返回 ErrIndexNotFound, 已关闭时返回 ErrIndexClosed
+func (s *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if err := s.check(tx); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.check(tx); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// check 检查当前索引是否仍然存在且未关闭, tx 为 nil 时只检查标记
+func (s *BoltStore) check(tx *bolt.Tx) error {
+	if atomic.LoadInt32(&s.dropped) != 0 {
+		return ErrIndexNotFound
+	}
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return ErrIndexClosed
+	}
+	if tx != nil && s.name != "" && tx.Bucket(indexesBucket).Bucket([]byte(s.name)) == nil {
+		return ErrIndexNotFound
+	}
+	return nil
+}
+
+func (s *BoltStore) IndexName() string {
+	return s.name
+}
+
+// CreateIndex 创建索引. 同名索引已存在时, schema 相同则直接返回, 否则返回 ErrIndexExists
+func (s *BoltStore) CreateIndex(spec *IndexSpec) error {
+	if err := spec.Check(); err != nil {
+		return err
+	}
+	if !indexNameRegexp.MatchString(spec.Name) {
+		return fmt.Errorf("%w: invalid index name %q", ErrBadIndexSpec, spec.Name)
+	}
+
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(indexesBucket).Bucket([]byte(spec.Name)) != nil {
+			if !bytes.Equal(tx.Bucket(schemaBucket).Get([]byte(spec.Name)), body) {
+				return fmt.Errorf("%w: %s", ErrIndexExists, spec.Name)
+			}
+			return nil
+		}
+
+		b, err := tx.Bucket(indexesBucket).CreateBucket([]byte(spec.Name))
+		if err != nil {
+			return err
+		}
+
+		for _, name := range dataBuckets {
+			if _, err := b.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(schemaBucket).Put([]byte(spec.Name), body)
+	})
+}
+
+func (s *BoltStore) ListIndexes() ([]*IndexSpec, error) {
+	var specs []*IndexSpec
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(indexesBucket).ForEach(func(k, v []byte) error {
+			spec := &IndexSpec{Name: string(k)}
+			if body := tx.Bucket(schemaBucket).Get(k); body != nil {
+				if err := json.Unmarshal(body, spec); err != nil {
+					return err
+				}
+			}
+
+			specs = append(specs, spec)
+			return nil
+		})
+	})
+
+	return specs, err
+}
+
+func (s *BoltStore) DropIndex(name string) error {
+	root := s.root
+	if name == "" {
+		return errors.New("can not drop default index")
+	}
+
+	root.indexMu.Lock()
+	defer root.indexMu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(indexesBucket).DeleteBucket([]byte(name)); err != nil {
+			if err == bolt.ErrBucketNotFound {
+				return ErrIndexNotFound
+			}
+			return err
+		}
+
+		return tx.Bucket(schemaBucket).Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+
+	// 已打开的 Store 标记为已删除, 段由引用计数释放, 正在遍历的迭代器仍可读完
+	if idx, ok := root.indexes[name]; ok {
+		atomic.StoreInt32(&idx.dropped, 1)
+
+		idx.mergeMu.Lock()
+		idx.mu.Lock()
+		segs := idx.segments
+		idx.segments = nil
+		idx.docPending, idx.tokenPending, idx.plPending = nil, nil, nil
+		idx.mu.Unlock()
+		idx.mergeMu.Unlock()
+
+		for _, seg := range segs {
+			seg.release()
+		}
+		delete(root.indexes, name)
+	}
+
+	return os.RemoveAll(root.indexSegmentDir(name))
+}
+
+func (s *BoltStore) indexSegmentDir(name string) string {
+	return filepath.Join(s.root.segmentDir, name)
+}
+
+func (s *BoltStore) Index(name string) (Store, error) {
+	root := s.root
+	if name == "" {
+		return root, nil
+	}
+
+	root.indexMu.Lock()
+	defer root.indexMu.Unlock()
+
+	if idx, ok := root.indexes[name]; ok {
+		return idx, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(indexesBucket).Bucket([]byte(name)) == nil {
+			return ErrIndexNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &BoltStore{
+		db:         s.db,
+		name:       name,
+		root:       root,
+		segmentDir: root.indexSegmentDir(name),
+	}
+	if err := idx.open(); err != nil {
+		return nil, err
+	}
+
+	root.indexes[name] = idx
+	return idx, nil
+}
+
+func (s *BoltStore) loadSegments() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return s.bucket(tx, segmentBucket).ForEach(func(k, v []byte) error {
+			f, err := OpenInvertFile(filepath.Join(s.segmentDir, string(v)))
+			if err != nil {
+				return err
+			}
+
+			s.segments = append(s.segments, newStoreSegment(btoi(k), f))
+			return nil
+		})
+	})
+}
+
+func (s *BoltStore) PutIndexSpec(spec *IndexSpec) error {
+	if err := spec.Check(); err != nil {
 		return err
+	}
+
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schemaBucket).Put([]byte(spec.Name), body)
 	})
+}
+
+func (s *BoltStore) GetIndexSpec(name string) (*IndexSpec, error) {
+	spec := &IndexSpec{}
 
-	return &BoltStore{db: db}, nil
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(schemaBucket).Get([]byte(name))
+		if v == nil {
+			return ErrIndexNotFound
+		}
+
+		return json.Unmarshal(v, spec)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return spec, nil
 }
 
 func (s *BoltStore) DocCount() (int, error) {
 	var c int
-	err := s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(docBucket)
+	err := s.view(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, docBucket)
 		c = b.Stats().KeyN
 		return nil
 	})
@@ -77,21 +480,25 @@ func (s *BoltStore) DocCount() (int, error) {
 }
 
 func (s *BoltStore) AddDoc(doc *Document) error {
-	s.db.Update(func(tx *bolt.Tx) error {
-		doc.ID, _ = tx.Bucket(docBucket).NextSequence()
-		return nil
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		doc.ID, err = s.bucket(tx, docBucket).NextSequence()
+		return err
 	})
+	if err != nil {
+		return err
+	}
 
 	if len(s.docPending) < flushTreshold {
 		s.docPending = append(s.docPending, doc)
 		return nil
 	}
 
-	return s.db.Update(s.flushDoc)
+	return s.update(s.flushDoc)
 }
 
 func (s *BoltStore) flushDoc(t *bolt.Tx) error {
-	b := t.Bucket(docBucket)
+	b := s.bucket(t, docBucket)
 
 	for _, doc := range s.docPending {
 		if doc.ID == 0 {
@@ -116,10 +523,16 @@ func (s *BoltStore) flushDoc(t *bolt.Tx) error {
 var ErrDocNotFound = errors.New("doc not found")
 
 func (s *BoltStore) GetDoc(id uint64) (*Document, error) {
-	doc := &Document{ID: id, Fields: make(map[string]string)}
+	for _, doc := range s.docPending {
+		if doc.ID == id {
+			return doc, nil
+		}
+	}
 
-	err := s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(docBucket)
+	doc := &Document{ID: id, Index: s.name, Fields: make(map[string]string)}
+
+	err := s.view(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, docBucket)
 		valBytes := b.Get(itob(id))
 		if valBytes == nil {
 			return ErrDocNotFound
@@ -140,34 +553,188 @@ func (s *BoltStore) GetDoc(id uint64) (*Document, error) {
 	return doc, nil
 }
 
-func (s *BoltStore) DelDoc(id uint64) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(docBucket)
-		return b.Delete(itob(id))
+// delDoc 删除文档的存储字段与外部 key 并记录 tombstone, 之后的扫描不再返回该文档的 posting,
+// 对应的 posting 在合并段时被物理删除
+func (s *BoltStore) delDoc(id uint64) error {
+	for i, doc := range s.docPending {
+		if doc.ID == id {
+			s.docPending = append(s.docPending[:i], s.docPending[i+1:]...)
+			break
+		}
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		if err := s.bucket(tx, deletedBucket).Put(itob(id), nil); err != nil {
+			return err
+		}
+
+		b := s.bucket(tx, docBucket)
+		if err := b.Delete(itob(id)); err != nil {
+			return err
+		}
+		if err := s.bucket(tx, docTermsBucket).Delete(itob(id)); err != nil {
+			return err
+		}
+
+		// 通过 UpsertDoc 写入的文档同时删除 key 的映射
+		ib := s.bucket(tx, docIDKeyBucket)
+		if key := ib.Get(itob(id)); key != nil {
+			if err := s.bucket(tx, docKeyBucket).Delete(key); err != nil {
+				return err
+			}
+			if err := ib.Delete(itob(id)); err != nil {
+				return err
+			}
+		}
+
+		s.mu.Lock()
+		s.deleted[id] = true
+		s.mu.Unlock()
+		return nil
+	})
+}
+
+func (s *BoltStore) upsertDoc(key string, doc *Document) (oldID uint64, err error) {
+	err = s.update(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, docBucket)
+		kb := s.bucket(tx, docKeyBucket)
+		ib := s.bucket(tx, docIDKeyBucket)
+
+		doc.ID, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(doc.Fields)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(itob(doc.ID), body); err != nil {
+			return err
+		}
+
+		if v := kb.Get([]byte(key)); v != nil {
+			oldID = btoi(v)
+			if err := s.bucket(tx, deletedBucket).Put(v, nil); err != nil {
+				return err
+			}
+			if err := b.Delete(v); err != nil {
+				return err
+			}
+			if err := s.bucket(tx, docTermsBucket).Delete(v); err != nil {
+				return err
+			}
+			if err := ib.Delete(v); err != nil {
+				return err
+			}
+		}
+
+		if err := kb.Put([]byte(key), itob(doc.ID)); err != nil {
+			return err
+		}
+		if err := ib.Put(itob(doc.ID), []byte(key)); err != nil {
+			return err
+		}
+
+		if oldID != 0 {
+			s.mu.Lock()
+			s.deleted[oldID] = true
+			s.mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// 旧文档可能还未写入 bbolt
+	for i, d := range s.docPending {
+		if oldID != 0 && d.ID == oldID {
+			s.docPending = append(s.docPending[:i], s.docPending[i+1:]...)
+			break
+		}
+	}
+
+	return oldID, nil
+}
+
+func (s *BoltStore) LookupDoc(key string) (id uint64, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		v := s.bucket(tx, docKeyBucket).Get([]byte(key))
+		if v == nil {
+			return ErrDocNotFound
+		}
+
+		id = btoi(v)
+		return nil
 	})
+	return id, err
 }
 
-func (s *BoltStore) AllocToken(token string) (tk *Token, err error) {
+func (s *BoltStore) putDocTerms(id uint64, fts []*fieldTerms) error {
+	body, err := json.Marshal(fts)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return s.bucket(tx, docTermsBucket).Put(itob(id), body)
+	})
+}
+
+// docTerms 返回 putDocTerms 保存的词频, 文档没有未保存的字段时返回 nil
+func (s *BoltStore) docTerms(id uint64) (fts []*fieldTerms, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		v := s.bucket(tx, docTermsBucket).Get(itob(id))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &fts)
+	})
+	return fts, err
+}
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// tokenKey 词元在 token bucket 中的 key: field + "\x00" + value, 旧版本没有字段的词元直接使用 value
+func tokenKey(field, value string) []byte {
+	if field == "" {
+		return []byte(value)
+	}
+	return []byte(field + "\x00" + value)
+}
+
+func parseTokenKey(k []byte) (field, value string) {
+	if i := bytes.IndexByte(k, 0); i >= 0 {
+		return string(k[:i]), string(k[i+1:])
+	}
+	return "", string(k)
+}
+
+func (s *BoltStore) AllocToken(field, token string) (tk *Token, err error) {
 	tk = &Token{}
 
-	err = s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(tokenBucket)
+	err = s.update(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, tokenBucket)
 
 		tk.ID, err = b.NextSequence()
 		return err
 	})
 
+	tk.Field = field
 	tk.Value = token
 	return tk, err
 }
 
-func (s *BoltStore) GetToken(token string) (tk *Token, err error) {
+// GetToken 返回字段 field 中的词元 token, 不存在时分配一个新的词元
+func (s *BoltStore) GetToken(field, token string) (tk *Token, err error) {
 	tk = &Token{}
 
-	err = s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(tokenBucket)
+	err = s.update(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, tokenBucket)
+		key := tokenKey(field, token)
 
-		tkVal := b.Get([]byte(token))
+		tkVal := b.Get(key)
 		if tkVal == nil {
 			tk.ID, err = b.NextSequence()
 			if err != nil {
@@ -175,39 +742,65 @@ func (s *BoltStore) GetToken(token string) (tk *Token, err error) {
 			}
 
 			j, _ := json.Marshal(tk)
-			return b.Put([]byte(token), j)
+			return b.Put(key, j)
 		}
 
 		return json.Unmarshal(tkVal, tk)
 	})
 
+	tk.Field = field
 	tk.Value = token
 	return tk, err
 }
 
+// LookupToken 与 GetToken 相同, 但词元不存在时返回 ErrTokenNotFound
+func (s *BoltStore) LookupToken(field, token string) (tk *Token, err error) {
+	tk = &Token{}
+
+	err = s.view(func(tx *bolt.Tx) error {
+		tkVal := s.bucket(tx, tokenBucket).Get(tokenKey(field, token))
+		if tkVal == nil {
+			return ErrTokenNotFound
+		}
+
+		return json.Unmarshal(tkVal, tk)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tk.Field = field
+	tk.Value = token
+	return tk, nil
+}
+
 func (s *BoltStore) UpdateToken(tk *Token) error {
+	if err := s.check(nil); err != nil {
+		return err
+	}
+
+	s.tokenPending = append(s.tokenPending, tk)
 	if len(s.tokenPending) < flushTreshold {
-		s.tokenPending = append(s.tokenPending, tk)
 		return nil
 	}
 
-	return s.db.Update(s.flushToken)
+	return s.update(s.flushToken)
 }
 
 func (s *BoltStore) flushToken(t *bolt.Tx) error {
-	b := t.Bucket(tokenBucket)
+	b := s.bucket(t, tokenBucket)
 
 	for _, tk := range s.tokenPending {
-		key := []byte(tk.Value)
-		tk.Value = ""
-		bytes, err := json.Marshal(tk)
+		// 字段和值已经在 key 中
+		val := *tk
+		val.Field, val.Value = "", ""
+
+		bytes, err := json.Marshal(&val)
 		if err != nil {
-			_ = t.Rollback()
 			return err
 		}
 
-		if err := b.Put(key, bytes); err != nil {
-			_ = t.Rollback()
+		if err := b.Put(tokenKey(tk.Field, tk.Value), bytes); err != nil {
 			return err
 		}
 
@@ -217,30 +810,58 @@ func (s *BoltStore) flushToken(t *bolt.Tx) error {
 	return nil
 }
 
+// GetFields 返回索引中全部字段的统计信息
+func (s *BoltStore) GetFields() ([]*FieldStats, error) {
+	var fields []*FieldStats
+
+	err := s.view(func(tx *bolt.Tx) error {
+		return s.bucket(tx, fieldBucket).ForEach(func(k, v []byte) error {
+			fs := &FieldStats{}
+			if err := json.Unmarshal(v, fs); err != nil {
+				return err
+			}
+
+			fs.Name = string(k)
+			fields = append(fields, fs)
+			return nil
+		})
+	})
+
+	return fields, err
+}
+
+func (s *BoltStore) UpdateField(fs *FieldStats) error {
+	body, err := json.Marshal(fs)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return s.bucket(tx, fieldBucket).Put([]byte(fs.Name), body)
+	})
+}
+
 func (s *BoltStore) AddPostingList(pl *PostingList) error {
+	if err := s.check(nil); err != nil {
+		return err
+	}
+
 	s.plPending = append(s.plPending, pl)
 
 	if len(s.plPending) < flushTreshold {
 		return nil
 	}
 
-	return s.db.Update(s.flushPostingList)
+	return s.update(s.flushPostingList)
 }
 
 func (s *BoltStore) flushPostingList(t *bolt.Tx) error {
-	b := t.Bucket(iiBucket)
+	b := s.bucket(t, iiBucket)
 
 	for _, pl := range s.plPending {
 		key := append(itob(pl.TokenID), itob(pl.DocID)...)
 
-		val, _ := json.Marshal(pl)
-
-		// buf := bytes.NewBuffer(make([]byte, 4*len(pl.PosList)))
-		// for _, pos := range pl.PosList {
-		// 	binary.Write(buf, binary.BigEndian, uint32(pos))
-		// }
-
-		if err := b.Put(key, val); err != nil {
+		if err := b.Put(key, encodePostingList(pl)); err != nil {
 			return err
 		}
 	}
@@ -249,9 +870,51 @@ func (s *BoltStore) flushPostingList(t *bolt.Tx) error {
 	return nil
 }
 
+func (s *BoltStore) AddSegment(iiMap map[uint64]map[uint64]*PostingList) error {
+	if err := s.check(nil); err != nil {
+		return err
+	}
+	if len(iiMap) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.segmentDir, 0755); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, segmentBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%08d.seg", seq)
+		path := filepath.Join(s.segmentDir, name)
+		if err := WriteInvertFile(path, iiMap); err != nil {
+			return err
+		}
+
+		f, err := OpenInvertFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(itob(seq), []byte(name)); err != nil {
+			f.Close()
+			return err
+		}
+
+		s.mu.Lock()
+		s.segments = append(s.segments, newStoreSegment(seq, f))
+		s.mu.Unlock()
+		return nil
+	})
+}
+
 func (s *BoltStore) ScanToken(f func(token *Token)) error {
-	return s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(tokenBucket)
+	return s.view(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, tokenBucket)
 
 		return b.ForEach(func(k, v []byte) error {
 			//var tokenID uint64
@@ -259,7 +922,7 @@ func (s *BoltStore) ScanToken(f func(token *Token)) error {
 
 			var tk Token
 			json.Unmarshal(v, &tk)
-			tk.Value = string(k)
+			tk.Field, tk.Value = parseTokenKey(k)
 			f(&tk)
 
 			return nil
@@ -268,8 +931,12 @@ func (s *BoltStore) ScanToken(f func(token *Token)) error {
 }
 
 func (s *BoltStore) ScanPostingListByToken(tokenID uint64, f func(pl *PostingList)) error {
-	return s.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(iiBucket).Cursor()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f = s.skipDeleted(f)
+
+	err := s.view(func(tx *bolt.Tx) error {
+		c := s.bucket(tx, iiBucket).Cursor()
 		prefix := itob(tokenID)
 		for k, v := c.Seek(prefix); k != nil; k, v = c.Next() {
 			if bytes.HasPrefix(k, prefix) {
@@ -281,22 +948,120 @@ func (s *BoltStore) ScanPostingListByToken(tokenID uint64, f func(pl *PostingLis
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range s.segments {
+		if err := seg.ScanPostingListByToken(tokenID, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostingListIterator 返回 tokenID 的 posting 迭代器, 跳过已删除的文档.
+// 迭代器持有段的引用, 遍历期间合并不会关闭正在读取的段
+func (s *BoltStore) PostingListIterator(tokenID uint64) (PostingIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var pending []*PostingList
+	err := s.view(func(tx *bolt.Tx) error {
+		c := s.bucket(tx, iiBucket).Cursor()
+		prefix := itob(tokenID)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if err := applyPostList(k, v, func(pl *PostingList) { pending = append(pending, pl) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		subs []PostingIterator
+		segs []*storeSegment
+	)
+	release := func() {
+		for _, seg := range segs {
+			seg.release()
+		}
+	}
+
+	if len(pending) > 0 {
+		subs = append(subs, newSliceIterator(pending))
+	}
+	for _, seg := range s.segments {
+		it, err := seg.Iterator(tokenID)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if it != nil {
+			seg.acquire()
+			segs = append(segs, seg)
+			subs = append(subs, it)
+		}
+	}
+
+	var skip func(docID uint64) bool
+	if len(s.deleted) > 0 {
+		deleted := s.deleted
+		skip = func(docID uint64) bool {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return deleted[docID]
+		}
+	}
+
+	return newMergedIterator(subs, skip, release), nil
 }
 
 func (s *BoltStore) ScanPostingList(f func(pl *PostingList)) error {
-	return s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(iiBucket)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f = s.skipDeleted(f)
+
+	err := s.view(func(tx *bolt.Tx) error {
+		b := s.bucket(tx, iiBucket)
 
 		return b.ForEach(func(k, v []byte) error {
 			applyPostList(k, v, f)
 			return nil
 		})
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range s.segments {
+		if err := seg.ScanPostingList(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipDeleted 过滤已删除文档的 posting, 调用方需持有 s.mu
+func (s *BoltStore) skipDeleted(f func(pl *PostingList)) func(pl *PostingList) {
+	if len(s.deleted) == 0 {
+		return f
+	}
+
+	return func(pl *PostingList) {
+		if !s.deleted[pl.DocID] {
+			f(pl)
+		}
+	}
 }
 
 func applyPostList(k, v []byte, f func(pl *PostingList)) error {
 	kb := bytes.NewBuffer(k)
-	// vb := bytes.NewBuffer(v)
 
 	var tokenID, docID uint64
 	if err := binary.Read(kb, binary.BigEndian, &tokenID); err != nil {
@@ -307,28 +1072,48 @@ func applyPostList(k, v []byte, f func(pl *PostingList)) error {
 	}
 
 	pl := &PostingList{}
-	json.Unmarshal(v, pl)
-
-	//fmt.Printf("len(v)=%d v/4=%d\n", len(v), len(v)/4)
-	// pl := make([]int, len(v)/4)
-	// i := 0
-	// for {
-	// 	var pos uint32
-	// 	binary.Read(vb, binary.BigEndian, &pos)
-	// 	//	fmt.Printf("i=%d v=%d\n", i, pos)
-	// 	pl[i] = int(pos)
-	// 	i++
-	// 	if i == len(v)/4 {
-	// 		break
-	// 	}
-	// }
+	if err := decodePostingList(v, pl); err != nil {
+		return err
+	}
+	pl.TokenID = tokenID
+	pl.DocID = docID
 
 	f(pl)
 	return nil
 }
 
+// Close 写入缓存的数据并关闭段文件. 关闭默认索引时同时关闭全部命名索引和数据库.
+// Close 写入尚未保存的数据并关闭 Store, 重复调用返回 nil. 索引已被删除时不需要写入
 func (s *BoltStore) Close() error {
-	s.db.Update(func(tx *bolt.Tx) error {
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return nil
+	}
+
+	var idxErr error
+	if s.root == s {
+		s.indexMu.Lock()
+		indexes := s.indexes
+		s.indexes = make(map[string]*BoltStore)
+		s.indexMu.Unlock()
+
+		for _, idx := range indexes {
+			if err := idx.Close(); err != nil && idxErr == nil {
+				idxErr = err
+			}
+		}
+	} else {
+		s.root.indexMu.Lock()
+		if s.root.indexes[s.name] == s {
+			delete(s.root.indexes, s.name)
+		}
+		s.root.indexMu.Unlock()
+	}
+
+	// 等待正在进行的合并完成
+	s.mergeMu.Lock()
+	defer s.mergeMu.Unlock()
+
+	err := s.update(func(tx *bolt.Tx) error {
 		if err := s.flushDoc(tx); err != nil {
 			return err
 		}
@@ -343,9 +1128,28 @@ func (s *BoltStore) Close() error {
 
 		return nil
 	})
+	if err == ErrIndexNotFound {
+		err = nil
+	}
+	if err == nil {
+		err = idxErr
+	}
+	atomic.StoreInt32(&s.closed, 1)
+
+	s.mu.Lock()
+	segs := s.segments
+	s.segments = nil
+	s.mu.Unlock()
+	for _, seg := range segs {
+		seg.release()
+	}
 
-	s.db.Close()
-	return nil
+	if s.root == s {
+		if cerr := s.db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
 func itob(v uint64) []byte {
@@ -353,3 +1157,7 @@ func itob(v uint64) []byte {
 	binary.BigEndian.PutUint64(b, uint64(v))
 	return b
 }
+
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
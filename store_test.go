@@ -0,0 +1,202 @@
+package tns_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/zhaoyao/tns"
+)
+
+func indexNames(t *testing.T, s tns.Store) []string {
+	specs, err := s.ListIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	return names
+}
+
+func TestStoreIndexes(t *testing.T) {
+	s, err := tns.CreateBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, name := range []string{"b", "a"} {
+		if err := s.CreateIndex(&tns.IndexSpec{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.CreateIndex(&tns.IndexSpec{Name: "a b"}); !errors.Is(err, tns.ErrBadIndexSpec) {
+		t.Fatalf("invalid name: %v", err)
+	}
+	if names := indexNames(t, s); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("indexes: %v", names)
+	}
+
+	// 重复创建: 相同的 schema 不报错, 不同的 schema 不能覆盖已有的索引
+	if err := s.CreateIndex(&tns.IndexSpec{Name: "a"}); err != nil {
+		t.Fatalf("create with same spec: %v", err)
+	}
+	text := &tns.FieldSpec{Name: "Text", Type: tns.FieldText, Indexed: true}
+	if err := s.CreateIndex(&tns.IndexSpec{Name: "a", Fields: []*tns.FieldSpec{text}}); !errors.Is(err, tns.ErrIndexExists) {
+		t.Fatalf("create with other spec: %v", err)
+	}
+	if spec, err := s.GetIndexSpec("a"); err != nil || len(spec.Fields) != 0 {
+		t.Fatalf("spec of a replaced: %+v, %v", spec, err)
+	}
+
+	a, _ := s.Index("a")
+	b, _ := s.Index("b")
+	if err := a.AddDoc(&tns.Document{Fields: map[string]string{"Text": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GetDoc(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetDoc(1); err != tns.ErrDocNotFound {
+		t.Fatalf("doc of a visible in b: %v", err)
+	}
+
+	if err := s.DropIndex("a"); err != nil {
+		t.Fatal(err)
+	}
+	if names := indexNames(t, s); len(names) != 1 || names[0] != "b" {
+		t.Fatalf("indexes after drop: %v", names)
+	}
+	if _, err := s.Index("a"); err != tns.ErrIndexNotFound {
+		t.Fatalf("Index(a) after drop: %v", err)
+	}
+	if err := s.DropIndex("a"); err != tns.ErrIndexNotFound {
+		t.Fatalf("drop twice: %v", err)
+	}
+	if err := s.DropIndex(""); err == nil {
+		t.Fatal("default index dropped")
+	}
+	if _, err := s.Index("missing"); err != tns.ErrIndexNotFound {
+		t.Fatalf("Index(missing): %v", err)
+	}
+}
+
+func TestStoreDroppedIndexHandle(t *testing.T) {
+	s, err := tns.CreateBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.CreateIndex(&tns.IndexSpec{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := s.Index("a")
+	for d := uint64(1); d <= 2; d++ {
+		err := a.AddSegment(map[uint64]map[uint64]*tns.PostingList{
+			1: {d: {TokenID: 1, DocID: d, DocLen: 1, PosList: []int{0}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := a.PostingListIterator(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DropIndex("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除前打开的迭代器仍然可以读完
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if err := it.Close(); err != nil || n != 2 {
+		t.Fatalf("iterator read %d postings, err %v", n, err)
+	}
+
+	for op, err := range map[string]error{
+		"DocCount": func() error { _, err := a.DocCount(); return err }(),
+		"AddDoc":   a.AddDoc(&tns.Document{Fields: map[string]string{"Text": "x"}}),
+		"GetDoc":   func() error { _, err := a.GetDoc(1); return err }(),
+		"GetToken": func() error { _, err := a.GetToken("Text", "x"); return err }(),
+		"AddSegment": a.AddSegment(map[uint64]map[uint64]*tns.PostingList{
+			1: {3: {TokenID: 1, DocID: 3, DocLen: 1, PosList: []int{0}}},
+		}),
+		"MergeSegments": a.MergeSegments(&tns.DefaultMergePolicy),
+		"ScanPostingList": a.ScanPostingList(func(pl *tns.PostingList) {
+			t.Fatalf("posting %+v of dropped index", pl)
+		}),
+		"PostingListIterator": func() error { _, err := a.PostingListIterator(1); return err }(),
+	} {
+		if err != tns.ErrIndexNotFound {
+			t.Fatalf("%s on dropped index: %v", op, err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同名的新索引与旧的 Store 无关
+	if err := s.CreateIndex(&tns.IndexSpec{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.DocCount(); err != tns.ErrIndexNotFound {
+		t.Fatalf("old handle sees new index: %v", err)
+	}
+	a2, err := s.Index("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := a2.DocCount(); err != nil || c != 0 {
+		t.Fatalf("new index DocCount = %d, %v", c, err)
+	}
+}
+
+func TestStoreClosedIndexHandle(t *testing.T) {
+	s, err := tns.CreateBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.CreateIndex(&tns.IndexSpec{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	a1, _ := s.Index("a")
+	a2, _ := s.Index("a")
+	if err := a1.AddDoc(&tns.Document{Fields: map[string]string{"Text": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭时写入缓冲的文档, 共享同一 Store 的其他持有者不再能读写
+	if err := a1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a2.DocCount(); err != tns.ErrIndexClosed {
+		t.Fatalf("DocCount on closed handle: %v", err)
+	}
+	if _, err := a2.GetDoc(1); err != tns.ErrIndexClosed {
+		t.Fatalf("GetDoc on closed handle: %v", err)
+	}
+	if err := a2.AddDoc(&tns.Document{Fields: map[string]string{"Text": "y"}}); err != tns.ErrIndexClosed {
+		t.Fatalf("AddDoc on closed handle: %v", err)
+	}
+	if err := a2.Close(); err != nil {
+		t.Fatalf("close twice: %v", err)
+	}
+
+	a3, err := s.Index("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := a3.DocCount(); err != nil || c != 1 {
+		t.Fatalf("reopened index DocCount = %d, %v", c, err)
+	}
+}
@@ -0,0 +1,158 @@
+package tns
+
+import (
+	"math"
+	"sort"
+)
+
+// wandMatcher 使用 WAND (Broder et al., 2003) 遍历多个词元的并集, 跳过打分上界之和
+// 不超过当前第 K 个结果分数 (threshold) 的文档.
+//
+// 每个词元的上界由打分函数对 "最大词频 + 最短字段长度" 打分得到. Similarity.WAND 为 true 的打分函数对单个词元的贡献
+// 随词频单调递增, 随字段长度单调递减, 且多个词元的得分不超过各自得分之和, 因此上界之和是文档得分的上界.
+type wandMatcher struct {
+	cursors []*postingMatcher
+	bounds  map[*postingMatcher]float64
+	doc     uint64
+
+	// threshold 只有分数高于它的文档才能进入结果
+	threshold float64
+	// skipped 是否跳过了命中的文档, 此时命中总数只是下界
+	skipped bool
+}
+
+// wandMatcher 只有由 TermQuery 组成的纯 OR 查询才能使用 WAND, 其余查询返回 nil
+func (s *Searcher) wandMatcher(q Query, allFields []string, sf ScoreFunc, ctx *ScoreContext) (*wandMatcher, error) {
+	var terms []*TermQuery
+	switch q := q.(type) {
+	case *TermQuery:
+		terms = append(terms, q)
+	case *BoolQuery:
+		if len(q.Must) > 0 || len(q.MustNot) > 0 {
+			return nil, nil
+		}
+		for _, c := range q.Should {
+			tq, ok := c.(*TermQuery)
+			if !ok {
+				return nil, nil
+			}
+			terms = append(terms, tq)
+		}
+	default:
+		return nil, nil
+	}
+
+	m := &wandMatcher{
+		bounds:    make(map[*postingMatcher]float64),
+		threshold: math.Inf(-1),
+	}
+	for _, tq := range terms {
+		pms, err := s.termPostings(tq, allFields)
+		if err != nil {
+			return nil, err
+		}
+		for _, pm := range pms {
+			m.cursors = append(m.cursors, pm)
+			m.bounds[pm] = termBound(pm.t, pm.field, sf, ctx)
+		}
+	}
+
+	m.next(0)
+	return m, nil
+}
+
+// termBound 词元得分的上界, 没有统计信息时为 +Inf
+func termBound(t *Token, field string, sf ScoreFunc, ctx *ScoreContext) float64 {
+	if t.MaxFreq == 0 || t.MinDocLen == 0 {
+		return math.Inf(1)
+	}
+
+	h := &Hit{hitTokens: []*termHit{{t: t, pl: make([]int, t.MaxFreq), field: field, docLen: t.MinDocLen}}}
+	score, _ := sf(h, ctx)
+
+	// idf 为负时词元的贡献不超过 0
+	return math.Max(score, 0)
+}
+
+func (m *wandMatcher) docID() uint64 {
+	return m.doc
+}
+
+func (m *wandMatcher) advance(target uint64) {
+	if m.doc >= target {
+		return
+	}
+	m.next(target)
+}
+
+// next 查找第一个 >= target 且上界之和超过 threshold 的文档
+func (m *wandMatcher) next(target uint64) {
+	for _, c := range m.cursors {
+		c.advance(target)
+	}
+
+	for {
+		sort.Slice(m.cursors, func(i, j int) bool { return m.cursors[i].docID() < m.cursors[j].docID() })
+
+		// pivot: 按 docID 排序后上界累加首次超过 threshold 的游标
+		pivot := -1
+		var acc float64
+		for i, c := range m.cursors {
+			if c.docID() == noMoreDocs {
+				break
+			}
+			acc += m.bounds[c]
+			if m.competitive(acc) {
+				pivot = i
+				break
+			}
+		}
+
+		if pivot < 0 {
+			if len(m.cursors) > 0 && m.cursors[0].docID() != noMoreDocs {
+				m.skipped = true
+			}
+			m.doc = noMoreDocs
+			return
+		}
+
+		pivotDoc := m.cursors[pivot].docID()
+		if m.cursors[0].docID() == pivotDoc {
+			m.doc = pivotDoc
+			return
+		}
+
+		// pivot 之前的文档不可能进入结果
+		for _, c := range m.cursors[:pivot] {
+			if c.docID() < pivotDoc {
+				c.advance(pivotDoc)
+				m.skipped = true
+			}
+		}
+	}
+}
+
+// competitive 上界之和为 ub 的文档是否可能进入结果. 分数相同时 docID 小的优先, 而 WAND 按 docID
+// 升序遍历, 因此上界等于 threshold 的文档理论上也可以跳过, 这里留出一点余量避免浮点误差
+func (m *wandMatcher) competitive(ub float64) bool {
+	return ub > m.threshold-1e-9*math.Abs(m.threshold)
+}
+
+func (m *wandMatcher) close() error {
+	var err error
+	for _, c := range m.cursors {
+		if e := c.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (m *wandMatcher) collect(hits []*termHit) []*termHit {
+	for _, c := range m.cursors {
+		if c.docID() == m.doc {
+			hits = c.collect(hits)
+		}
+	}
+	return hits
+}
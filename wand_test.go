@@ -0,0 +1,115 @@
+package tns
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// wandTestSearcher 随机生成 Title / Text 两个字段的文档, 词元的词频与字段长度各不相同
+func wandTestSearcher(t *testing.T) *Searcher {
+	r := rand.New(rand.NewSource(1))
+	words := func(n int) string {
+		w := make([]string, n)
+		for i := range w {
+			// 词频大致服从 Zipf 分布
+			w[i] = fmt.Sprintf("w%d", int(math.Pow(r.Float64(), 2)*12))
+		}
+		return strings.Join(w, " ")
+	}
+
+	docs := make([]map[string]string, 300)
+	for i := range docs {
+		docs[i] = map[string]string{"Title": words(1 + r.Intn(4)), "Text": words(1 + r.Intn(40))}
+	}
+	return testSearcher(t, testFields, docs...)
+}
+
+// checkWAND 比较 WAND 剪枝与 ExactTotal 的前 size 个结果, 两者累加词元得分的顺序可能不同
+func checkWAND(t *testing.T, s *Searcher, q string, opts SearchOptions) {
+	t.Helper()
+
+	exactOpts := opts
+	exactOpts.ExactTotal = true
+	exact, err := s.SearchWith(q, &exactOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.SearchWith(q, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Hits) != len(exact.Hits) {
+		t.Fatalf("%s %q: got %d hits, want %d", opts.Score, q, len(got.Hits), len(exact.Hits))
+	}
+	for i, h := range got.Hits {
+		if e := exact.Hits[i]; h.Doc.ID != e.Doc.ID || math.Abs(h.Score-e.Score) > 1e-9 {
+			t.Fatalf("%s %q: hit %d is doc %d (%v), want doc %d (%v)", opts.Score, q, i, h.Doc.ID, h.Score, e.Doc.ID, e.Score)
+		}
+	}
+	if got.Total > exact.Total || (!got.TotalLowerBound && got.Total != exact.Total) {
+		t.Fatalf("%s %q: total %d (lower bound %v), exact %d", opts.Score, q, got.Total, got.TotalLowerBound, exact.Total)
+	}
+}
+
+func TestWANDTopK(t *testing.T) {
+	s := wandTestSearcher(t)
+
+	queries := []string{"w0", "w1 w7", "w0 w3 w11", "Title:w2 Text:w5 w9", "w4 w6 w8 w10"}
+	for _, name := range Similarities() {
+		sim, _ := GetSimilarity(name)
+		if !sim.WAND {
+			continue
+		}
+		for _, q := range queries {
+			for _, size := range []int{1, 5, 20} {
+				checkWAND(t, s, q, SearchOptions{Size: size, Score: name})
+			}
+		}
+	}
+
+	// BM25F 多个字段使用不同的权重与 b
+	bm25f := &BM25FParams{K1: 1.5, Boosts: map[string]float64{"Title": 3}, B: map[string]float64{"Title": 0.3, "Text": 0.9}}
+	for _, q := range queries {
+		checkWAND(t, s, q, SearchOptions{Size: 5, Score: "bm25f", BM25F: bm25f})
+	}
+}
+
+func TestWANDNoBound(t *testing.T) {
+	s := wandTestSearcher(t)
+
+	// 旧版本的词元没有 MaxFreq, 上界为 +Inf, 不能跳过任何文档
+	if b := termBound(&Token{DocCount: 1}, "Text", bm25, s.scoreContext(&SearchOptions{})); !math.IsInf(b, 1) {
+		t.Fatalf("bound without MaxFreq: %v", b)
+	}
+
+	for _, field := range []string{"Title", "Text"} {
+		tk, err := s.store.LookupToken(field, "w1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tk.MaxFreq = 0
+		if err := s.store.UpdateToken(tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bs := s.store.(*BoltStore)
+	if err := bs.update(bs.flushToken); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, score := range []string{"bm25", "bm25f"} {
+		checkWAND(t, s, "w1 w7", SearchOptions{Size: 5, Score: score})
+
+		hits, err := s.SearchWith("w1", &SearchOptions{Size: 1, Score: score})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hits.TotalLowerBound {
+			t.Fatalf("%s: documents of a token without bound were skipped", score)
+		}
+	}
+}